@@ -2,4 +2,8 @@ module rpucella.net/webgen
 
 go 1.16
 
-require github.com/russross/blackfriday/v2 v2.1.0
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/russross/blackfriday/v2 v2.1.0
+	gopkg.in/yaml.v3 v3.0.1
+)
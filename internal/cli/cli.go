@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"rpucella.net/webgen"
+	"rpucella.net/webgen/internal/gen"
+	"sort"
+	"strings"
+)
+
+type Command struct {
+	Name string
+	// Args is a synopsis of the arguments, for usage messages.
+	Args    string
+	Summary string
+	// New registers the flags specific to the command, if any, bound to
+	// fresh values, and returns the function running the command with them.
+	New func(fs *flag.FlagSet) Runner
+}
+
+// A Runner runs a command with the options common to all commands.
+
+type Runner func(opts *Options, args []string) error
+
+func noFlags(run Runner) func(fs *flag.FlagSet) Runner {
+	return func(*flag.FlagSet) Runner { return run }
+}
+
+// Options common to all commands.
+
+type Options struct {
+	Verbose bool
+	Quiet   bool
+	Debug   bool
+	// LogFormat is text or json.
+	LogFormat string
+	Root      string
+	Out       string
+	Config    string
+	// Jobs is the number of files generated in parallel, or 0 for the default.
+	Jobs int
+	// Manifest is the file listing the generated files, or "" for the default.
+	Manifest string
+
+	log gen.Logger
+	// The folder the command was run from, relative to the root.
+	dir string
+}
+
+func defaultOptions() *Options {
+	return &Options{false, false, false, "text", "", "", "", 0, "", gen.DefaultLogger(), "."}
+}
+
+func (opts *Options) register(fs *flag.FlagSet) {
+	// Common options can be given before or after the command name,
+	// so the current values are used as defaults.
+	fs.BoolVar(&opts.Verbose, "v", opts.Verbose, "verbose output")
+	fs.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "verbose output")
+	fs.BoolVar(&opts.Quiet, "q", opts.Quiet, "only report errors and warnings")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "only report errors and warnings")
+	fs.BoolVar(&opts.Debug, "debug", opts.Debug, "debugging output")
+	fs.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "`format` of progress and error messages on standard error: text or json")
+	fs.StringVar(&opts.Root, "root", opts.Root, "root `folder` of the site, under which templates, data files and shortcodes are looked up (default: the nearest enclosing folder with "+gen.CONFIGFILE+", or else the outermost enclosing folder with a "+gen.GENDIR+" folder)")
+	fs.StringVar(&opts.Out, "out", opts.Out, "output `folder` (default: the root folder)")
+	fs.IntVar(&opts.Jobs, "j", opts.Jobs, "number of files generated in parallel (default: number of CPUs)")
+	fs.IntVar(&opts.Jobs, "jobs", opts.Jobs, "number of files generated in parallel (default: number of CPUs)")
+	fs.StringVar(&opts.Manifest, "manifest", opts.Manifest, "`file` listing the generated files (default: "+gen.MANIFEST+" in the output, or next to the output folder with --out)")
+	fs.StringVar(&opts.Config, "config", opts.Config, "configuration `file` (default: "+gen.CONFIGFILE+" in the root folder)")
+}
+
+var errUsage = errors.New("usage")
+
+// Main runs the command given by args, and returns the exit code.
+// Without a command, runs the default command if there is one.
+// For compatibility, an argument that is not a command but is an existing
+// file or folder is passed to the default command.
+
+func Main(prog string, args []string, commands []Command, defaultCommand string) int {
+	opts := defaultOptions()
+	global := flag.NewFlagSet(prog, flag.ContinueOnError)
+	global.SetOutput(os.Stderr)
+	opts.register(global)
+	global.Usage = func() { Usage(os.Stderr, prog, commands) }
+	if err := global.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	args = global.Args()
+	if len(args) == 0 {
+		if defaultCommand == "" {
+			Usage(os.Stderr, prog, commands)
+			return 2
+		}
+		args = []string{defaultCommand}
+	}
+	if _, ok := findCommand(commands, args[0]); !ok && defaultCommand != "" {
+		if _, err := os.Stat(args[0]); err == nil {
+			args = append([]string{defaultCommand}, args...)
+		}
+	}
+	name := args[0]
+	if name == "help" {
+		if len(args) > 1 {
+			if cmd, ok := findCommand(commands, args[1]); ok {
+				fs, _ := newFlagSet(prog, cmd, defaultOptions())
+				commandUsage(os.Stdout, prog, cmd, fs)
+				return 0
+			}
+		}
+		Usage(os.Stdout, prog, commands)
+		return 0
+	}
+	cmd, ok := findCommand(commands, name)
+	if !ok {
+		opts.errorf("unknown command %s", name)
+		Usage(os.Stderr, prog, commands)
+		return 2
+	}
+	fs, run := newFlagSet(prog, cmd, opts)
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if err := opts.setLogger(); err != nil {
+		opts.errorf("%s", err)
+		return 2
+	}
+	if err := opts.chdir(); err != nil {
+		opts.errorf("%s", err)
+		return 1
+	}
+	if err := run(opts, fs.Args()); err != nil {
+		if err == errUsage {
+			commandUsage(os.Stderr, prog, cmd, fs)
+			return 2
+		}
+		opts.errorf("%s", err)
+		return 1
+	}
+	return 0
+}
+
+func findCommand(commands []Command, name string) (Command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+func newFlagSet(prog string, cmd Command, opts *Options) (*flag.FlagSet, Runner) {
+	fs := flag.NewFlagSet(prog+" "+cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	opts.register(fs)
+	run := cmd.New(fs)
+	fs.Usage = func() { commandUsage(os.Stderr, prog, cmd, fs) }
+	return fs, run
+}
+
+func (opts *Options) setLogger() error {
+	// Diagnostics go to standard error, so that generated output
+	// on standard output is not mixed with them.
+	level := gen.LevelInfo
+	switch {
+	case opts.Quiet:
+		level = gen.LevelQuiet
+	case opts.Debug:
+		level = gen.LevelDebug
+	case opts.Verbose:
+		level = gen.LevelVerbose
+	}
+	switch opts.LogFormat {
+	case "text":
+		opts.log = gen.NewTextLogger(os.Stderr, level)
+	case "json":
+		opts.log = gen.NewJSONLogger(os.Stderr, level)
+	default:
+		return fmt.Errorf("unknown log format %s", opts.LogFormat)
+	}
+	return nil
+}
+
+func (opts *Options) chdir() error {
+	// Commands run from the root folder of the site.
+	// The output folder and the configuration file are relative to the original folder.
+	// Without --root, so are the files and folders given as arguments.
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if opts.Root == "" {
+		opts.Root = findRoot(cwd)
+		dir, err := filepath.Rel(opts.Root, cwd)
+		if err != nil {
+			return err
+		}
+		opts.dir = dir
+	}
+	if opts.Out != "" {
+		opts.Out = absPath(cwd, opts.Out)
+	}
+	if opts.Config != "" {
+		opts.Config = absPath(cwd, opts.Config)
+	}
+	if opts.Manifest != "" {
+		opts.Manifest = absPath(cwd, opts.Manifest)
+	}
+	return os.Chdir(opts.Root)
+}
+
+func findRoot(dir string) string {
+	// The nearest enclosing folder with a configuration file, or else the
+	// outermost of the enclosing folders with a __src folder, or else dir.
+	root := dir
+	found := false
+	for ; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, gen.CONFIGFILE)); err == nil {
+			return dir
+		}
+		if hasGenDir(dir) {
+			root = dir
+			found = true
+		} else if found {
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return root
+}
+
+func hasGenDir(dir string) bool {
+	for _, name := range []string{gen.GENDIR, "." + gen.GENDIR} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *Options) path(name string) string {
+	// A file or folder given as an argument, relative to the root.
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(opts.dir, name)
+}
+
+func (opts *Options) loadConfig() (gen.Config, error) {
+	// Only a configuration file given with --config must exist.
+	if opts.Config != "" {
+		return gen.LoadConfig(opts.Config)
+	}
+	return gen.LoadConfigFS(os.DirFS("."), gen.CONFIGFILE)
+}
+
+func (opts *Options) builder(drafts bool) (*webgen.Builder, error) {
+	return webgen.New(webgen.Options{Root: ".", Out: opts.Out, Config: opts.Config, Logger: opts.log, Workers: opts.Jobs, Manifest: opts.Manifest, Drafts: drafts})
+}
+
+func (opts *Options) loadSite(drafts bool) (*gen.Site, error) {
+	b, err := opts.builder(drafts)
+	if err != nil {
+		return nil, err
+	}
+	return b.LoadSite()
+}
+
+func (opts *Options) errorf(format string, args ...interface{}) {
+	opts.log.Log(gen.LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+func (opts *Options) infof(format string, args ...interface{}) {
+	opts.log.Log(gen.LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+func (opts *Options) verbosef(format string, args ...interface{}) {
+	opts.log.Log(gen.LevelVerbose, fmt.Sprintf(format, args...), nil)
+}
+
+func Usage(w io.Writer, prog string, commands []Command) {
+	fmt.Fprintf(w, "USAGE: %s <command> [<flag>...] <arg>...\n", prog)
+	fmt.Fprintln(w, "  commands := ")
+	width := 0
+	for _, cmd := range commands {
+		if len(cmd.Name) > width {
+			width = len(cmd.Name)
+		}
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %-*s  %s\n", width, cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(w, "  use \"%s help <command>\" for the flags of a command\n", prog)
+}
+
+func commandUsage(w io.Writer, prog string, cmd Command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "USAGE: %s %s [<flag>...] %s\n", prog, cmd.Name, cmd.Args)
+	fmt.Fprintf(w, "  %s\n", cmd.Summary)
+	fmt.Fprintln(w, "  flags := ")
+	names := make([]string, 0)
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		arg, usage := flag.UnquoteUsage(f)
+		dashes := "--"
+		if len(name) == 1 {
+			dashes = "-"
+		}
+		line := strings.TrimSpace(fmt.Sprintf("%s%s %s", dashes, name, arg))
+		if !isZeroValue(f) {
+			usage = fmt.Sprintf("%s (default %q)", usage, f.DefValue)
+		}
+		fmt.Fprintf(w, "    %-24s %s\n", line, usage)
+	}
+}
+
+func isZeroValue(f *flag.Flag) bool {
+	// Whether the default of a flag is the zero value of its type, as in flag.PrintDefaults.
+	typ := reflect.TypeOf(f.Value)
+	var zero reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		zero = reflect.New(typ.Elem())
+	} else {
+		zero = reflect.Zero(typ)
+	}
+	return f.DefValue == zero.Interface().(flag.Value).String()
+}
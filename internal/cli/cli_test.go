@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"rpucella.net/webgen/internal/gen"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fname := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func TestFindRoot(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"site/__src/index.md":           "",
+		"site/blog/__src/index.md":      "",
+		"site/blog/posts/x.txt":         "",
+		"other/" + gen.CONFIGFILE:       "",
+		"other/__src/index.md":          "",
+		"other/sub/__src/index.md":      "",
+		"other/sub/deep/__src/index.md": "",
+		"plain/x.txt":                   "",
+	})
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"site", "site"},
+		{"site/blog", "site"},
+		// Folders without a __src folder of their own are inside the site.
+		{"site/blog/posts", "site"},
+		{"other/sub/deep", "other"},
+		{"plain", "plain"},
+	}
+	for _, test := range tests {
+		dir := filepath.Join(root, filepath.FromSlash(test.dir))
+		want := filepath.Join(root, filepath.FromSlash(test.want))
+		if got := findRoot(dir); got != want {
+			t.Errorf("findRoot(%s) = %s, want %s", test.dir, got, want)
+		}
+	}
+}
+
+func TestBuildFromSubfolder(t *testing.T) {
+	// Templates of the enclosing folders apply when building from a folder of the site.
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"__src/" + gen.TEMPLATE:   "<html>{{.Body}}</html>\n",
+		"__src/" + gen.MDTEMPLATE: "<h1>{{.Title}}</h1>\n{{.Body}}",
+		"__src/index.md":          "---\ntitle: Home\n---\n",
+		"blog/__src/post.md":      "---\ntitle: Post\n---\nHello\n",
+	})
+	chdir(t, filepath.Join(root, "blog"))
+	if code := Main("webgen", []string{"-q", "build"}, Commands, "build"); code != 0 {
+		t.Fatalf("webgen build exited with %d", code)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "blog", "post.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "<html><h1>Post</h1>") {
+		t.Errorf("blog/post.html = %q, want the templates of the site applied", data)
+	}
+	// Only the folder is built.
+	if _, err := os.Stat(filepath.Join(root, "index.html")); err == nil {
+		t.Errorf("index.html was built from folder blog")
+	}
+}
@@ -0,0 +1,524 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"rpucella.net/webgen/internal/gen"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+func draftsFlag(fs *flag.FlagSet, drafts *bool) {
+	fs.BoolVar(drafts, "drafts", false, "include collection items marked as drafts")
+}
+
+type buildFlags struct {
+	dryRun bool
+	drafts bool
+}
+
+var buildCommand = Command{
+	Name:    "build",
+	Args:    "[<folder> | <file.content> | <file.md>]",
+	Summary: "generate the site, a folder of the site, or a single file to standard output",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &buildFlags{}
+		fs.BoolVar(&f.dryRun, "dry-run", false, "list the files that would be generated, with their source and templates, without writing anything")
+		draftsFlag(fs, &f.drafts)
+		return f.run
+	},
+}
+
+type serveFlags struct {
+	addr     string
+	watch    bool
+	interval time.Duration
+	drafts   bool
+}
+
+var serveCommand = Command{
+	Name:    "serve",
+	Summary: "build the site and serve it over HTTP",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &serveFlags{}
+		fs.StringVar(&f.addr, "addr", "localhost:8000", "`address` to listen on")
+		fs.BoolVar(&f.watch, "watch", false, "rebuild the site when sources change")
+		fs.DurationVar(&f.interval, "interval", time.Second, "`interval` between checks for changes")
+		draftsFlag(fs, &f.drafts)
+		return f.run
+	},
+}
+
+type watchFlags struct {
+	interval time.Duration
+	drafts   bool
+}
+
+var watchCommand = Command{
+	Name:    "watch",
+	Summary: "build the site, and rebuild it whenever sources change",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &watchFlags{}
+		fs.DurationVar(&f.interval, "interval", time.Second, "`interval` between checks for changes")
+		draftsFlag(fs, &f.drafts)
+		return f.run
+	},
+}
+
+var explainCommand = Command{
+	Name:    "explain",
+	Args:    "<file.content> | <file.md> | <collection folder>",
+	Summary: "show how the templates of a file are found, and in what order they apply",
+	New:     noFlags(runExplain),
+}
+
+type checkFlags struct {
+	drafts bool
+}
+
+var checkCommand = Command{
+	Name:    "check",
+	Summary: "check that generated files are up to date, printing the differences",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &checkFlags{}
+		draftsFlag(fs, &f.drafts)
+		return f.run
+	},
+}
+
+var cleanCommand = Command{
+	Name:    "clean",
+	Summary: "remove the files generated by the last build",
+	New:     noFlags(runClean),
+}
+
+type newFlags struct {
+	title string
+}
+
+var newCommand = Command{
+	Name:    "new",
+	Args:    "<file.md>",
+	Summary: "create a new markdown page in the __src folder of the given folder",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &newFlags{}
+		fs.StringVar(&f.title, "title", "", "`title` of the page (default: derived from the file name)")
+		return f.run
+	},
+}
+
+var listCommand = Command{
+	Name:    "list",
+	Summary: "list the pages and collection items of the site",
+	New:     noFlags(runList),
+}
+
+var initCommand = Command{
+	Name:    "init",
+	Args:    "[<folder>]",
+	Summary: "create a skeleton site",
+	New:     noFlags(runInit),
+}
+
+// Commands of webgen, with the default command first.
+
+var Commands = []Command{
+	buildCommand,
+	serveCommand,
+	watchCommand,
+	checkCommand,
+	explainCommand,
+	cleanCommand,
+	newCommand,
+	draftCommand,
+	listCommand,
+	initCommand,
+}
+
+// Commands of weblog.
+
+var PostCommands = []Command{
+	newPostCommand,
+	listPostsCommand,
+	publishCommand,
+	unpublishCommand,
+	movePostCommand,
+	draftCommand,
+}
+
+func (f *buildFlags) run(opts *Options, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	target := opts.path(".")
+	if len(args) == 1 {
+		target = opts.path(args[0])
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if f.dryRun {
+			return dryRun(opts, target, f.drafts)
+		}
+		return build(opts, target, f.drafts)
+	}
+	if f.dryRun {
+		return fmt.Errorf("--dry-run only applies to folders")
+	}
+	b, err := opts.builder(f.drafts)
+	if err != nil {
+		return err
+	}
+	output, err := b.RenderFile(target)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+func build(opts *Options, target string, drafts bool) error {
+	b, err := opts.builder(drafts)
+	if err != nil {
+		return err
+	}
+	result, err := b.BuildFolder(context.Background(), target)
+	if err != nil {
+		return err
+	}
+	return buildErr(result)
+}
+
+func buildErr(result *gen.BuildResult) error {
+	// Errors are logged as they happen, so only their number is reported.
+	switch len(result.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("failed with 1 error")
+	}
+	return fmt.Errorf("failed with %d errors", len(result.Errors))
+}
+
+func (f *checkFlags) run(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	b, err := opts.builder(f.drafts)
+	if err != nil {
+		return err
+	}
+	diffs, result, err := b.Check(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Print(d.Diff())
+	}
+	if err := buildErr(result); err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d generated files are out of date", len(diffs))
+	}
+	opts.infof("generated files are up to date\n")
+	return nil
+}
+
+func runClean(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	b, err := opts.builder(false)
+	if err != nil {
+		return err
+	}
+	return buildErr(b.Clean())
+}
+
+func dryRun(opts *Options, target string, drafts bool) error {
+	b, err := opts.builder(drafts)
+	if err != nil {
+		return err
+	}
+	result, err := b.DryRun(context.Background(), target)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "SOURCE\tOUTPUT\tTEMPLATES\n")
+	for _, e := range result.Manifest.Folders {
+		fmt.Fprintf(w, "%s\t%s/\t\n", e.Source, e.File)
+	}
+	for _, e := range result.Manifest.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Source, e.File, strings.Join(e.Templates, ", "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return buildErr(result)
+}
+
+func runExplain(opts *Options, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	b, err := opts.builder(false)
+	if err != nil {
+		return err
+	}
+	explanation, err := b.Explain(opts.path(args[0]))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("file: %s\n", explanation.File)
+	if explanation.Generated != "" {
+		fmt.Printf("generated as: %s\n", explanation.Generated)
+	}
+	if explanation.Layout != "" {
+		fmt.Printf("layout: %s\n", explanation.Layout)
+	}
+	fmt.Printf("lookup:\n")
+	for _, step := range explanation.Steps {
+		fmt.Printf("  %s\n", step)
+	}
+	fmt.Printf("templates, in the order in which they apply:\n")
+	for i, t := range explanation.Templates {
+		if len(t.Extends) > 0 {
+			fmt.Printf("  %d. %s (extends %s)\n", i+1, t.Name, strings.Join(t.Extends, ", "))
+		} else {
+			fmt.Printf("  %d. %s\n", i+1, t.Name)
+		}
+	}
+	return nil
+}
+
+func (f *serveFlags) run(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if opts.Out != "" {
+		dir = opts.Out
+	}
+	if f.watch {
+		go func() {
+			if err := watch(opts, f.interval, f.drafts); err != nil {
+				opts.errorf("%s", err)
+			}
+		}()
+	} else if err := build(opts, ".", f.drafts); err != nil {
+		return err
+	}
+	opts.infof("serving %s on http://%s/\n", dir, f.addr)
+	return http.ListenAndServe(f.addr, http.FileServer(http.Dir(dir)))
+}
+
+func (f *watchFlags) run(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return watch(opts, f.interval, f.drafts)
+}
+
+func watch(opts *Options, interval time.Duration, drafts bool) error {
+	// Poll the site for changes, rebuilding after every change.
+	// The state of the site is recorded after a build, so that the
+	// files written by the build do not trigger another build.
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := build(opts, ".", drafts); err != nil {
+		opts.errorf("%s", err)
+	}
+	state, err := fingerprint(root, opts.Out)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(interval)
+		current, err := fingerprint(root, opts.Out)
+		if err != nil {
+			return err
+		}
+		if current == state {
+			continue
+		}
+		opts.infof("change detected, rebuilding\n")
+		if err := build(opts, ".", drafts); err != nil {
+			opts.errorf("%s", err)
+		}
+		state, err = fingerprint(root, opts.Out)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func fingerprint(root string, skip string) (uint64, error) {
+	// A hash of the names, sizes, and modification times of all files under root.
+	h := fnv.New64a()
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// File may have been removed while walking.
+			return nil
+		}
+		if d.IsDir() {
+			if path == skip || d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, fi.Size(), fi.ModTime().UnixNano())
+		return nil
+	}
+	if err := filepath.WalkDir(root, walk); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+func (f *newFlags) run(opts *Options, args []string) error {
+	if len(args) != 1 || !gen.IsMarkdown(args[0]) {
+		return errUsage
+	}
+	dir := filepath.Dir(opts.path(args[0]))
+	name := filepath.Base(args[0])
+	base := strings.TrimSuffix(name, ".md")
+	if base == "" {
+		return fmt.Errorf("missing file name in %s", args[0])
+	}
+	genDir := gen.GENDIR
+	if _, err := os.Stat(filepath.Join(dir, "."+gen.GENDIR)); err == nil {
+		genDir = "." + gen.GENDIR
+	}
+	if err := os.MkdirAll(filepath.Join(dir, genDir), 0755); err != nil {
+		return err
+	}
+	fname := filepath.Join(dir, genDir, name)
+	title := f.title
+	if title == "" {
+		title = strings.ReplaceAll(base, "-", " ")
+		first, size := utf8.DecodeRuneInString(title)
+		title = string(unicode.ToUpper(first)) + title[size:]
+	}
+	md := fmt.Sprintf("---\ntitle: %s\ndate: %s\n---\n\n", title, time.Now().Format("2006-01-02"))
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(md); err != nil {
+		file.Close()
+		return err
+	}
+	opts.infof("created %s\n", fname)
+	return file.Close()
+}
+
+func runList(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	b, err := opts.builder(false)
+	if err != nil {
+		return err
+	}
+	pages, err := b.ListPages()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "URL\tTITLE\tDATE\tCOLLECTION\n")
+	for _, page := range pages {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", page.URL, page.Title, page.FormattedDate, page.Collection)
+	}
+	return w.Flush()
+}
+
+const initConfig = `title = "My site"
+baseurl = ""
+author = ""
+`
+
+const initContentTemplate = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>{{.Title}}</title>
+  </head>
+  <body>
+{{.Body}}
+  </body>
+</html>
+`
+
+const initMarkdownTemplate = `<h1>{{.Title}}</h1>
+{{.Body}}
+`
+
+const initIndex = `---
+title: Welcome
+---
+
+This is the front page of the site.
+`
+
+func runInit(opts *Options, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir := opts.path(".")
+	if len(args) == 1 {
+		dir = opts.path(args[0])
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{gen.CONFIGFILE, initConfig},
+		{filepath.Join(gen.GENDIR, gen.TEMPLATE), initContentTemplate},
+		{filepath.Join(gen.GENDIR, gen.MDTEMPLATE), initMarkdownTemplate},
+		{filepath.Join(gen.GENDIR, "index.md"), initIndex},
+	}
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(dir, f.name)); err == nil {
+			return fmt.Errorf("%s already exists", filepath.Join(dir, f.name))
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, gen.GENDIR), 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		fname := filepath.Join(dir, f.name)
+		if err := os.WriteFile(fname, []byte(f.content), 0644); err != nil {
+			return err
+		}
+		opts.infof("created %s\n", fname)
+	}
+	return nil
+}
+
+func absPath(cwd string, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(cwd, path)
+}
@@ -0,0 +1,317 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"rpucella.net/webgen/internal/gen"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Drafts are previewed in a browser, from a file in the cache folder of the
+// user or served over HTTP. The browser is the command given with --open, or
+// else the one from the configuration file, or else $BROWSER, or else the
+// opener of the system. Draft files are reused for a given markdown file, so
+// that they do not pile up.
+
+type draftFlags struct {
+	open     string
+	site     bool
+	stdout   bool
+	serve    bool
+	addr     string
+	interval time.Duration
+}
+
+var draftCommand = Command{
+	Name:    "draft",
+	Args:    "<file.md>",
+	Summary: "preview a markdown file in the browser",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &draftFlags{}
+		fs.StringVar(&f.open, "open", "", "`command` opening the draft, %s is the file or URL; none to not open it")
+		fs.BoolVar(&f.site, "site", false, "render the draft with the templates of the site rather than the built-in draft template")
+		fs.BoolVar(&f.stdout, "stdout", false, "print the draft to standard output")
+		fs.BoolVar(&f.serve, "serve", false, "serve the draft over HTTP, reloading it when sources change")
+		fs.StringVar(&f.addr, "addr", "localhost:8000", "`address` to listen on, with --serve")
+		fs.DurationVar(&f.interval, "interval", time.Second, "`interval` between checks for changes, with --serve")
+		return f.run
+	},
+}
+
+func (f *draftFlags) run(opts *Options, args []string) error {
+	if len(args) != 1 || !gen.IsMarkdown(args[0]) {
+		return errUsage
+	}
+	if f.stdout && f.serve {
+		return fmt.Errorf("cannot use both --stdout and --serve")
+	}
+	fname := path.Clean(filepath.ToSlash(opts.path(args[0])))
+	if !fs.ValidPath(fname) {
+		return fmt.Errorf("%s is not inside the root folder", args[0])
+	}
+	if f.serve {
+		return f.serveDraft(opts, fname)
+	}
+	output, err := renderDraft(opts, fname, f.site)
+	if err != nil {
+		return err
+	}
+	if f.stdout {
+		_, err := os.Stdout.Write(output)
+		return err
+	}
+	browser, err := draftBrowser(opts, f.open)
+	if err != nil {
+		return err
+	}
+	if f.site {
+		// Relative links of the page are resolved from the folder where it would be generated.
+		out, err := outputDir(opts)
+		if err != nil {
+			return err
+		}
+		site, err := opts.loadSite(true)
+		if err != nil {
+			return err
+		}
+		base := filepath.Join(out, filepath.FromSlash(path.Dir(draftURL(site, fname))))
+		output = injectHead(output, fmt.Sprintf("<base href=\"%s\">", html.EscapeString(fileURL(base)+"/")))
+	}
+	dir, err := draftDir()
+	if err != nil {
+		return err
+	}
+	name := "webgen-draft-" + strings.ReplaceAll(strings.TrimSuffix(fname, ".md"), "/", "-") + ".html"
+	target := filepath.Join(dir, name)
+	if err := os.WriteFile(target, output, 0600); err != nil {
+		return err
+	}
+	opts.infof("wrote draft %s\n", target)
+	if browser == "" {
+		return nil
+	}
+	if err := openBrowser(browser, target); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
+func draftDir() (string, error) {
+	// Draft files are kept in a folder of the user rather than directly in
+	// the temporary folder, where a file with a known name could be replaced
+	// by anyone, e.g., with a symlink.
+	if cache, err := os.UserCacheDir(); err == nil {
+		dir := filepath.Join(cache, "webgen", "drafts")
+		if err := os.MkdirAll(dir, 0700); err == nil {
+			return dir, nil
+		}
+	}
+	return os.MkdirTemp("", "webgen-drafts-")
+}
+
+func renderDraft(opts *Options, fname string, withSite bool) ([]byte, error) {
+	// The site is loaded anew every time, so that a served draft picks up changes.
+	// It includes drafts, so that the draft can be found among the items.
+	site, err := opts.loadSite(true)
+	if err != nil {
+		return nil, err
+	}
+	return gen.NewBuild(os.DirFS("."), gen.NewMemOutput(), site, false, opts.log).RenderDraft(fname, withSite)
+}
+
+func draftURL(site *gen.Site, fname string) string {
+	// Where the draft would be generated, as known to the site, e.g., in
+	// the folder of its key for a post. Otherwise, next to its __src folder.
+	if page := site.PageBySource(fname); page != nil {
+		return page.URL
+	}
+	dir := path.Dir(fname)
+	name := strings.TrimSuffix(path.Base(fname), path.Ext(fname)) + ".html"
+	if base := path.Base(dir); base == gen.GENDIR || base == "."+gen.GENDIR {
+		dir = path.Dir(dir)
+	}
+	return path.Join("/", dir, name)
+}
+
+func outputDir(opts *Options) (string, error) {
+	if opts.Out != "" {
+		return opts.Out, nil
+	}
+	return os.Getwd()
+}
+
+func fileURL(name string) string {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, "/") {
+		// A Windows path, with a drive letter.
+		name = "/" + name
+	}
+	return "file://" + name
+}
+
+func draftBrowser(opts *Options, open string) (string, error) {
+	// The command opening drafts, or "" for none.
+	browser := open
+	if browser == "" {
+		config, err := opts.loadConfig()
+		if err != nil {
+			return "", err
+		}
+		browser = config.Browser
+	}
+	if browser == "" {
+		// $BROWSER is a list of commands, of which we take the first one.
+		browser = strings.Split(os.Getenv("BROWSER"), string(os.PathListSeparator))[0]
+	}
+	if browser == "" {
+		switch runtime.GOOS {
+		case "darwin":
+			browser = "open"
+		case "windows":
+			browser = "rundll32 url.dll,FileProtocolHandler"
+		default:
+			browser = "xdg-open"
+		}
+	}
+	if browser == "none" {
+		return "", nil
+	}
+	return browser, nil
+}
+
+func browserCommand(browser string, target string) *exec.Cmd {
+	words := strings.Fields(browser)
+	found := false
+	for i, word := range words {
+		if strings.Contains(word, "%s") {
+			words[i] = strings.ReplaceAll(word, "%s", target)
+			found = true
+		}
+	}
+	if !found {
+		words = append(words, target)
+	}
+	return exec.Command(words[0], words[1:]...)
+}
+
+func openBrowser(browser string, target string) error {
+	cmd := browserCommand(browser, target)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot open %s with %s: %s", target, browser, err)
+	}
+	return nil
+}
+
+const reloadScript = `
+<script>
+  (function () {
+    var version = "%s";
+    setInterval(function () {
+      fetch("%s").then(function (response) { return response.text(); }).then(function (current) {
+        if (current !== version) {
+          location.reload();
+        }
+      });
+    }, %d);
+  })();
+</script>
+`
+
+const draftVersionURL = "/.webgen-draft/version"
+
+func (f *draftFlags) serveDraft(opts *Options, fname string) error {
+	// The draft is served where it would be generated, and the other files
+	// from the output, so that links to the rest of the site work. The page
+	// polls for changes to the sources and reloads itself.
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	out, err := outputDir(opts)
+	if err != nil {
+		return err
+	}
+	version := func() string {
+		state, err := fingerprint(root, opts.Out)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%x", state)
+	}
+	site, err := opts.loadSite(true)
+	if err != nil {
+		return err
+	}
+	url := draftURL(site, fname)
+	mux := http.NewServeMux()
+	mux.HandleFunc(url, func(w http.ResponseWriter, r *http.Request) {
+		script := []byte(fmt.Sprintf(reloadScript, version(), draftVersionURL, f.interval.Milliseconds()))
+		output, err := renderDraft(opts, fname, f.site)
+		if err != nil {
+			opts.errorf("%s", err)
+			output = []byte(fmt.Sprintf("<!DOCTYPE html>\n<html>\n  <body>\n    <pre>%s</pre>\n  </body>\n</html>\n", html.EscapeString(err.Error())))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(injectBody(output, script))
+	})
+	mux.HandleFunc(draftVersionURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, version())
+	})
+	mux.Handle("/", http.FileServer(http.Dir(out)))
+	browser, err := draftBrowser(opts, f.open)
+	if err != nil {
+		return err
+	}
+	// Listen before opening the browser.
+	listener, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	address := "http://" + f.addr + url
+	opts.infof("serving %s on %s\n", fname, address)
+	if browser != "" {
+		go func() {
+			// Some openers only return when the browser is closed.
+			if err := openBrowser(browser, address); err != nil {
+				opts.errorf("%s", err)
+			}
+		}()
+	}
+	return http.Serve(listener, mux)
+}
+
+func injectHead(page []byte, snippet string) []byte {
+	// Insert snippet right after <head>, or at the beginning if there is none.
+	lower := strings.ToLower(string(page))
+	idx := strings.Index(lower, "<head>")
+	if idx < 0 {
+		return append([]byte(snippet), page...)
+	}
+	idx += len("<head>")
+	return []byte(string(page[:idx]) + snippet + string(page[idx:]))
+}
+
+func injectBody(page []byte, snippet []byte) []byte {
+	// Insert snippet right before </body>, or at the end if there is none.
+	lower := strings.ToLower(string(page))
+	idx := strings.LastIndex(lower, "</body>")
+	if idx < 0 {
+		return append(page, snippet...)
+	}
+	return []byte(string(page[:idx]) + string(snippet) + string(page[idx:]))
+}
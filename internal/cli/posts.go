@@ -0,0 +1,600 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"rpucella.net/webgen/internal/gen"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Commands of weblog to manage the posts of a site, in the POSTS folder of
+// a __src folder. Sites usually have a single POSTS folder; otherwise, the
+// folder of the site containing the POSTS folder is given with --dir.
+
+func postsDirFlag(fs *flag.FlagSet, dir *string) {
+	fs.StringVar(dir, "dir", "", "`folder` of the site containing the POSTS folder (default: the only one)")
+}
+
+type newPostFlags struct {
+	dir  string
+	tags string
+}
+
+var newPostCommand = Command{
+	Name:    "new",
+	Args:    "<title>",
+	Summary: "create a draft post, in folder <year>/<slug> of the POSTS folder",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &newPostFlags{}
+		postsDirFlag(fs, &f.dir)
+		fs.StringVar(&f.tags, "tags", "", "comma-separated `tags` of the post")
+		return f.run
+	},
+}
+
+type listPostsFlags struct {
+	dir       string
+	year      int
+	tag       string
+	drafts    bool
+	published bool
+	since     string
+	until     string
+	sort      string
+	json      bool
+}
+
+var listPostsCommand = Command{
+	Name:    "list",
+	Summary: "list the posts, with their metadata",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &listPostsFlags{}
+		postsDirFlag(fs, &f.dir)
+		fs.IntVar(&f.year, "year", 0, "only list the posts of `year`")
+		fs.StringVar(&f.tag, "tag", "", "only list the posts with `tag`")
+		fs.BoolVar(&f.drafts, "drafts", false, "only list drafts")
+		fs.BoolVar(&f.published, "published", false, "only list posts that are not drafts")
+		fs.StringVar(&f.since, "since", "", "only list the posts dated `YYYY-MM-DD` or later")
+		fs.StringVar(&f.until, "until", "", "only list the posts dated `YYYY-MM-DD` or earlier")
+		fs.StringVar(&f.sort, "sort", "date", "sort `order`: date (most recent first) or title; prefix with - to reverse")
+		fs.BoolVar(&f.json, "json", false, "output JSON")
+		return f.run
+	},
+}
+
+func findPostsFolder(opts *Options, postsDir string) (string, error) {
+	// The POSTS folder to use, relative to the root, which may not exist yet.
+	// postsDir is the folder given with --dir, or "".
+	site, err := opts.loadSite(false)
+	if err != nil {
+		return "", err
+	}
+	folders := site.CollectionFolders(gen.GENPOSTS)
+	if postsDir != "" {
+		dir := path.Clean(filepath.ToSlash(opts.path(postsDir)))
+		for _, folder := range folders {
+			if path.Dir(path.Dir(folder)) == dir {
+				return folder, nil
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "."+gen.GENDIR)); err == nil {
+			return path.Join(dir, "."+gen.GENDIR, gen.GENPOSTS), nil
+		}
+		return path.Join(dir, gen.GENDIR, gen.GENPOSTS), nil
+	}
+	switch len(folders) {
+	case 0:
+		return path.Join(gen.GENDIR, gen.GENPOSTS), nil
+	case 1:
+		return folders[0], nil
+	}
+	return "", fmt.Errorf("several POSTS folders, use --dir to pick one of %s", strings.Join(folders, ", "))
+}
+
+func findPostsFolders(opts *Options, postsDir string) ([]string, error) {
+	// The existing POSTS folders to use, relative to the root.
+	if postsDir != "" {
+		folder, err := findPostsFolder(opts, postsDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.FromSlash(folder)); err != nil {
+			return nil, err
+		}
+		return []string{folder}, nil
+	}
+	site, err := opts.loadSite(false)
+	if err != nil {
+		return nil, err
+	}
+	return site.CollectionFolders(gen.GENPOSTS), nil
+}
+
+func postsCollection(opts *Options) (gen.Collection, error) {
+	// The POSTS collection, as configured.
+	config, err := opts.loadConfig()
+	if err != nil {
+		return gen.Collection{}, err
+	}
+	for _, coll := range config.AllCollections() {
+		if coll.Name == gen.GENPOSTS {
+			return coll, nil
+		}
+	}
+	return gen.Collection{}, fmt.Errorf("no %s collection", gen.GENPOSTS)
+}
+
+func splitTags(tags string) []string {
+	result := make([]string, 0)
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func (f *newPostFlags) run(opts *Options, args []string) error {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return errUsage
+	}
+	title := strings.TrimSpace(args[0])
+	slug := gen.Slugify(title)
+	if slug == "" {
+		return fmt.Errorf("cannot derive a folder name from title %q", title)
+	}
+	collPath, err := findPostsFolder(opts, f.dir)
+	if err != nil {
+		return err
+	}
+	date := time.Now()
+	folder := path.Join(collPath, fmt.Sprintf("%04d", date.Year()), slug)
+	if _, err := os.Stat(filepath.FromSlash(folder)); err == nil {
+		return fmt.Errorf("post %s already exists", folder)
+	}
+	md, err := gen.NewItem(os.DirFS("."), collPath, gen.Archetype{Title: title, Date: date, Slug: slug, Tags: splitTags(f.tags)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.FromSlash(folder), 0755); err != nil {
+		return err
+	}
+	fname := filepath.Join(filepath.FromSlash(folder), gen.POSTMD)
+	file, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(md); err != nil {
+		file.Close()
+		return err
+	}
+	opts.infof("created %s\n", fname)
+	return file.Close()
+}
+
+// A post as listed by weblog list --json.
+
+type postListing struct {
+	Key string `json:"key"`
+	// Folder is the POSTS folder containing the post.
+	Folder  string   `json:"folder"`
+	Title   string   `json:"title"`
+	Date    string   `json:"date"`
+	Reading string   `json:"reading"`
+	Draft   bool     `json:"draft"`
+	Tags    []string `json:"tags"`
+}
+
+func parseDay(day string) (time.Time, error) {
+	if day == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", day)
+}
+
+func (f *listPostsFlags) run(opts *Options, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	since, err := parseDay(f.since)
+	if err != nil {
+		return fmt.Errorf("invalid date for --since: %s", f.since)
+	}
+	until, err := parseDay(f.until)
+	if err != nil {
+		return fmt.Errorf("invalid date for --until: %s", f.until)
+	}
+	order := strings.TrimPrefix(f.sort, "-")
+	if order != "date" && order != "title" {
+		return fmt.Errorf("unknown sort order %s", f.sort)
+	}
+	folders, err := findPostsFolders(opts, f.dir)
+	if err != nil {
+		return err
+	}
+	coll, err := postsCollection(opts)
+	if err != nil {
+		return err
+	}
+	posts := make([]postListing, 0)
+	for _, folder := range folders {
+		items, err := gen.ExtractCollection(os.DirFS("."), folder, coll)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			tags := splitTags(item.Params["tags"])
+			switch {
+			case f.year != 0 && item.Year != f.year:
+				continue
+			case f.tag != "" && !containsTag(tags, f.tag):
+				continue
+			case f.drafts && !item.IsDraft(), f.published && item.IsDraft():
+				continue
+			case !since.IsZero() && (item.Date.IsZero() || item.Date.Before(since)):
+				continue
+			case !until.IsZero() && (item.Date.IsZero() || item.Date.After(until)):
+				continue
+			}
+			date := ""
+			if !item.Date.IsZero() {
+				date = item.Date.Format("2006-01-02")
+			}
+			posts = append(posts, postListing{item.Key, folder, item.Title, date, item.Reading, item.IsDraft(), tags})
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if strings.HasPrefix(f.sort, "-") {
+			i, j = j, i
+		}
+		if order == "title" {
+			return posts[i].Title < posts[j].Title
+		}
+		// Dates as YYYY-MM-DD sort as strings; most recent first.
+		return posts[i].Date > posts[j].Date
+	})
+	if f.json {
+		data, err := json.MarshalIndent(posts, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("%s\n", data)
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tTITLE\tDATE\tREADING\tDRAFT\tTAGS\n")
+	for _, post := range posts {
+		key := post.Key
+		if len(folders) > 1 {
+			key = path.Join(post.Folder, post.Key)
+		}
+		draft := ""
+		if post.Draft {
+			draft = "draft"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key, post.Title, post.Date, post.Reading, draft, strings.Join(post.Tags, ", "))
+	}
+	return w.Flush()
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+type publishFlags struct {
+	dir  string
+	date string
+	move bool
+}
+
+var publishCommand = Command{
+	Name:    "publish",
+	Args:    "<key>",
+	Summary: "publish a draft post, dating it today",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &publishFlags{}
+		postsDirFlag(fs, &f.dir)
+		fs.StringVar(&f.date, "date", "", "publication date `YYYY-MM-DD` (default: today)")
+		fs.BoolVar(&f.move, "move", false, "move the post into the folder of the year of its date")
+		return f.run
+	},
+}
+
+type unpublishFlags struct {
+	dir string
+}
+
+var unpublishCommand = Command{
+	Name:    "unpublish",
+	Args:    "<key>",
+	Summary: "turn a post back into a draft",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &unpublishFlags{}
+		postsDirFlag(fs, &f.dir)
+		return f.run
+	},
+}
+
+func findPost(opts *Options, postsDir string, key string) (string, string, error) {
+	// The POSTS folder and the source folder of the post with the given key,
+	// possibly prefixed with its POSTS folder as listed by weblog list. The key
+	// is the source folder of the post, or its key in the output when the
+	// collection has a permalink.
+	key = path.Clean(filepath.ToSlash(key))
+	if !fs.ValidPath(key) || key == "." {
+		return "", "", fmt.Errorf("invalid post %s", key)
+	}
+	coll, err := postsCollection(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if postsDir == "" {
+		folders, err := findPostsFolders(opts, postsDir)
+		if err != nil {
+			return "", "", err
+		}
+		found := make([]string, 0)
+		sources := make([]string, 0)
+		for _, folder := range folders {
+			if rest := strings.TrimPrefix(key, folder+"/"); rest != key {
+				if source, ok := postSource(folder, coll, rest); ok {
+					return folder, source, nil
+				}
+			}
+			if source, ok := postSource(folder, coll, key); ok {
+				found = append(found, folder)
+				sources = append(sources, source)
+			}
+		}
+		switch len(found) {
+		case 1:
+			return found[0], sources[0], nil
+		case 0:
+			if len(folders) > 1 {
+				return "", "", fmt.Errorf("no post %s", key)
+			}
+		default:
+			return "", "", fmt.Errorf("several posts %s, use --dir to pick one of %s", key, strings.Join(found, ", "))
+		}
+	}
+	collPath, err := findPostsFolder(opts, postsDir)
+	if err != nil {
+		return "", "", err
+	}
+	source, ok := postSource(collPath, coll, key)
+	if !ok {
+		return "", "", fmt.Errorf("no post %s in %s", key, collPath)
+	}
+	return collPath, source, nil
+}
+
+func postSource(collPath string, coll gen.Collection, key string) (string, bool) {
+	// The source folder of the post with the given source folder or key.
+	if isPost(path.Join(collPath, key)) {
+		return key, true
+	}
+	items, err := gen.ExtractCollection(os.DirFS("."), collPath, coll)
+	if err != nil {
+		return "", false
+	}
+	for _, item := range items {
+		if item.Key == key {
+			return item.Source, true
+		}
+	}
+	return "", false
+}
+
+func postKey(collPath string, coll gen.Collection, source string) (string, error) {
+	// The key of the post in the output, from the permalink of the collection.
+	items, err := gen.ExtractCollection(os.DirFS("."), collPath, coll)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range items {
+		if item.Source == source {
+			return item.Key, nil
+		}
+	}
+	return "", fmt.Errorf("no post %s in %s", source, collPath)
+}
+
+func isPost(folder string) bool {
+	_, err := os.Stat(filepath.Join(filepath.FromSlash(folder), gen.POSTMD))
+	return err == nil
+}
+
+func editPost(folder string, edit func([]byte) []byte) error {
+	// Rewrite the markdown file of a post.
+	md, err := os.ReadFile(filepath.Join(filepath.FromSlash(folder), gen.POSTMD))
+	if err != nil {
+		return err
+	}
+	return writePost(folder, edit(md))
+}
+
+func writePost(folder string, md []byte) error {
+	// Replace the markdown file of a post atomically.
+	f, err := gen.NewDirOutput(filepath.FromSlash(folder)).Create(gen.POSTMD)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(md); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func movePost(collPath string, key string, newKey string) error {
+	// Move the source folder of a post, removing the old year folder if it is left empty.
+	from := filepath.FromSlash(path.Join(collPath, key))
+	to := filepath.FromSlash(path.Join(collPath, newKey))
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("post %s already exists", path.Join(collPath, newKey))
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if dir := path.Dir(key); dir != "." {
+		os.Remove(filepath.FromSlash(path.Join(collPath, dir)))
+	}
+	return nil
+}
+
+func (f *publishFlags) run(opts *Options, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	date := time.Now()
+	if f.date != "" {
+		day, err := parseDay(f.date)
+		if err != nil {
+			return fmt.Errorf("invalid date for --date: %s", f.date)
+		}
+		date = day
+	}
+	collPath, key, err := findPost(opts, f.dir, args[0])
+	if err != nil {
+		return err
+	}
+	folder := path.Join(collPath, key)
+	err = editPost(folder, func(md []byte) []byte {
+		return gen.SetField(gen.RemoveField(md, "draft"), "date", date.Format("2006-01-02"))
+	})
+	if err != nil {
+		return err
+	}
+	opts.infof("published %s on %s\n", folder, date.Format("2006-01-02"))
+	newKey := path.Join(fmt.Sprintf("%04d", date.Year()), path.Base(key))
+	if !f.move || newKey == key {
+		return nil
+	}
+	if err := movePost(collPath, key, newKey); err != nil {
+		return err
+	}
+	opts.infof("moved %s to %s\n", folder, path.Join(collPath, newKey))
+	return nil
+}
+
+func (f *unpublishFlags) run(opts *Options, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	collPath, key, err := findPost(opts, f.dir, args[0])
+	if err != nil {
+		return err
+	}
+	folder := path.Join(collPath, key)
+	err = editPost(folder, func(md []byte) []byte {
+		return gen.SetField(md, "draft", "true")
+	})
+	if err != nil {
+		return err
+	}
+	opts.infof("unpublished %s\n", folder)
+	return nil
+}
+
+type movePostFlags struct {
+	dir string
+}
+
+var movePostCommand = Command{
+	Name:    "mv",
+	Args:    "<key> <new-folder>",
+	Summary: "move a post to another folder of POSTS, redirecting from its old location",
+	New: func(fs *flag.FlagSet) Runner {
+		f := &movePostFlags{}
+		postsDirFlag(fs, &f.dir)
+		return f.run
+	},
+}
+
+func (f *movePostFlags) run(opts *Options, args []string) error {
+	// The post is given by its key, as for the other commands, and moved to
+	// a folder relative to its POSTS folder, which is also its new key unless
+	// the collection has a permalink. The old key is recorded as an alias.
+	if len(args) != 2 {
+		return errUsage
+	}
+	collPath, source, err := findPost(opts, f.dir, args[0])
+	if err != nil {
+		return err
+	}
+	newSource := path.Clean(filepath.ToSlash(args[1]))
+	if !fs.ValidPath(newSource) || newSource == "." {
+		return fmt.Errorf("invalid post folder %s", args[1])
+	}
+	if newSource == source {
+		return nil
+	}
+	coll, err := postsCollection(opts)
+	if err != nil {
+		return err
+	}
+	// Aliases are keys in the output, which depend on the permalink of the
+	// collection. They are computed, and the post edited, before moving it.
+	items, err := gen.ExtractCollection(os.DirFS("."), collPath, coll)
+	if err != nil {
+		return err
+	}
+	var from, to string
+	for _, item := range items {
+		if item.Source == source {
+			from = item.Key
+			to = gen.ItemKey(coll, item, newSource)
+		}
+	}
+	if from == "" {
+		return fmt.Errorf("no post %s in %s", source, collPath)
+	}
+	folder := path.Join(collPath, source)
+	md, err := os.ReadFile(filepath.Join(filepath.FromSlash(folder), gen.POSTMD))
+	if err != nil {
+		return err
+	}
+	metadata, _, err := gen.ExtractMetadata(md)
+	if err != nil {
+		return fmt.Errorf("%s: %s", folder, err)
+	}
+	// Record the old key as an alias, and forget the new one if the post moves back.
+	aliases := make([]string, 0)
+	for _, alias := range splitTags(metadata.Params["aliases"]) {
+		if alias != to && alias != from {
+			aliases = append(aliases, alias)
+		}
+	}
+	aliases = append(aliases, from)
+	edited := gen.SetField(md, "aliases", strings.Join(aliases, ", "))
+	if err := movePost(collPath, source, newSource); err != nil {
+		return err
+	}
+	if to != from {
+		if err := writePost(path.Join(collPath, newSource), edited); err != nil {
+			// Without the alias, the old location would break.
+			if rerr := movePost(collPath, newSource, source); rerr != nil {
+				return fmt.Errorf("%s, and cannot move %s back: %s", err, path.Join(collPath, newSource), rerr)
+			}
+			return err
+		}
+	}
+	opts.infof("moved %s to %s\n", folder, path.Join(collPath, newSource))
+	return nil
+}
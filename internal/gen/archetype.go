@@ -0,0 +1,78 @@
+package gen
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// New collection items start with front matter giving their title, date,
+// draft status, and tags, followed by a body generated from the nearest
+// ARCHETYPE.template enclosing the collection folder, if there is one.
+// The archetype is a text template, executed with an Archetype.
+
+type Archetype struct {
+	Title string
+	Date  time.Time
+	Slug  string
+	Tags  []string
+}
+
+// NewItem returns the index.md file of a new item of collection folder collPath.
+
+func NewItem(fsys fs.FS, collPath string, a Archetype) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "---\ntitle: %s\ndate: %s\ndraft: true\n", a.Title, a.Date.Format("2006-01-02"))
+	if len(a.Tags) > 0 {
+		fmt.Fprintf(&b, "tags: %s\n", strings.Join(a.Tags, ", "))
+	}
+	b.WriteString("---\n\n")
+	tname := findArchetype(fsys, collPath)
+	if tname == "" {
+		return []byte(b.String()), nil
+	}
+	src, err := fs.ReadFile(fsys, tname)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New(path.Base(tname)).Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	if err := tpl.Execute(&b, a); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+func findArchetype(fsys fs.FS, collPath string) string {
+	// Look in the __src folder of the collection folder, and up.
+	for _, dir := range parentDirs(path.Dir(collPath)) {
+		gdPath, err := identifyGenDirPath(fsys, dir)
+		if err != nil {
+			continue
+		}
+		tname := path.Join(gdPath, ARCHETYPE)
+		if _, err := fs.Stat(fsys, tname); err == nil {
+			return tname
+		}
+	}
+	return ""
+}
+
+// CollectionFolders returns the source folders of a collection, in order.
+
+func (site *Site) CollectionFolders(name string) []string {
+	result := make([]string, 0)
+	for collPath := range site.items {
+		if path.Base(collPath) == name {
+			result = append(result, collPath)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,225 @@
+package gen
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// A build reads the sources of a site from a file system and writes the
+// generated files to an output. Paths are slash-separated and relative to
+// the root of the file system, which is the root of the site.
+//
+// When building in place, the output is the source folder itself: the files
+// generated into __src folders are written to the output, and static files
+// are already there. Otherwise, the files generated into __src folders are
+// only kept in memory for later phases, and static files are copied to the
+// output after generating the site.
+//
+// Each phase of a build first collects the files to generate, and then
+// generates them in parallel (see tasks.go).
+
+type Build struct {
+	fsys    *overlayFS
+	res     *resolver
+	out     *stagedOutput
+	site    *Site
+	result  *BuildResult
+	inPlace bool
+	log     Logger
+	workers int
+	own     *ownership
+	// Where the manifest is kept, nil if nowhere.
+	manifest *manifestFile
+	// Set when running as a task of a phase: writes to the output are
+	// delayed until the task is merged into the build, in order.
+	task    bool
+	pending []pendingOutput
+}
+
+type pendingOutput struct {
+	name  string
+	phase string
+	apply func(Output) error
+}
+
+// A nil logger gives the default logger.
+
+func NewBuild(src fs.FS, out Output, site *Site, inPlace bool, logger Logger) *Build {
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+	fsys := newOverlayFS(src)
+	var manifest *manifestFile
+	if readable, ok := out.(ReadableOutput); ok {
+		manifest = &manifestFile{readable, MANIFEST, false}
+	}
+	return &Build{fsys, newResolver(fsys), &stagedOutput{out, nil}, site, NewBuildResult(), inPlace, logger, runtime.GOMAXPROCS(0), newOwnership(), manifest, false, nil}
+}
+
+// SetWorkers sets the number of files generated in parallel.
+// It defaults to GOMAXPROCS.
+
+func (b *Build) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	b.workers = n
+}
+
+// Exclude hides a folder of the sources from the build, e.g., an output
+// folder inside the source folder.
+
+func (b *Build) Exclude(name string) {
+	b.fsys.remove(name)
+}
+
+// Run generates the site (or the part of the site) under root.
+
+func (b *Build) Run(ctx context.Context, root string) *BuildResult {
+	start := time.Now()
+	prev, found, ok := b.readManifest()
+	b.loadOwnership(prev, found)
+	if ok {
+		b.excludeStale(prev)
+	}
+	b.run(ctx, root)
+	b.commitStages(ctx)
+	if ok {
+		b.updateManifest(ctx, root, prev)
+	}
+	b.log.Log(LevelInfo, "built site", Fields{"root": root, "files": len(b.result.Written), "errors": len(b.result.Errors), "duration": time.Since(start)})
+	return b.result
+}
+
+func (b *Build) run(ctx context.Context, root string) {
+	if err := b.WalkAndProcessCollections(ctx, root); err != nil {
+		b.reportError(root, PhaseCollections, err)
+		return
+	}
+	if err := b.WalkAndProcessMarkdowns(ctx, root); err != nil {
+		b.reportError(root, PhaseMarkdown, err)
+		return
+	}
+	if err := b.WalkAndProcessContents(ctx, root); err != nil {
+		b.reportError(root, PhaseContent, err)
+		return
+	}
+	if !b.inPlace {
+		if err := b.copyStatic(ctx, root); err != nil {
+			b.reportError(root, PhaseStatic, err)
+		}
+	}
+}
+
+func (b *Build) isOutput(name string) bool {
+	// Files in __src folders are not part of the output, unless building in place.
+	if b.inPlace {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if isGenDir(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Build) toOutput(name string, phase string, apply func(Output) error) error {
+	if b.task {
+		b.pending = append(b.pending, pendingOutput{name, phase, apply})
+		return nil
+	}
+	return apply(b.out)
+}
+
+func (b *Build) writeFile(name string, src string, phase string, data []byte) error {
+	if err := b.checkWrite(name, src, data); err != nil {
+		return err
+	}
+	b.fsys.add(name, data)
+	if !b.isOutput(name) {
+		return nil
+	}
+	return b.toOutput(name, phase, func(out Output) error {
+		if err := out.MkdirAll(path.Dir(name)); err != nil {
+			return err
+		}
+		return writeOutput(out, name, data)
+	})
+}
+
+func writeOutput(out Output, name string, data []byte) error {
+	w, err := out.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (b *Build) reportError(file string, phase string, err error) {
+	b.log.Log(LevelError, err.Error(), Fields{"file": file, "phase": phase})
+	b.result.addError(file, phase, err)
+}
+
+func (b *Build) reportWritten(name string, src string, templates []string, phase string, start time.Time) {
+	fields := Fields{"file": name, "source": src, "phase": phase, "duration": time.Since(start)}
+	if b.isOutput(name) {
+		b.log.Log(LevelInfo, "wrote", fields)
+		b.addWritten(name, src, templates)
+	} else {
+		b.log.Log(LevelVerbose, "generated", fields)
+	}
+}
+
+func (b *Build) addWritten(name string, src string, templates []string) {
+	if b.isOutput(name) {
+		data, _ := b.fsys.ReadFile(name)
+		b.result.addWritten(name, src, templates, data)
+	}
+}
+
+func (b *Build) copyStatic(ctx context.Context, root string) error {
+	// Copy the files that are not generated to the output.
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isSkippedDirectory(p) {
+				return fs.SkipDir
+			}
+			return b.out.MkdirAll(p)
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		if b.fsys.isGenerated(p) {
+			if err := b.checkStatic(p); err != nil {
+				b.reportError(p, PhaseStatic, err)
+			}
+			return nil
+		}
+		data, err := fs.ReadFile(b.fsys, p)
+		if err != nil {
+			return err
+		}
+		if err := writeOutput(b.out, p, data); err != nil {
+			return err
+		}
+		b.log.Log(LevelDebug, "copied", Fields{"file": p, "phase": PhaseStatic})
+		b.result.addWritten(p, p, nil, data)
+		return nil
+	}
+	return fs.WalkDir(b.fsys, root, walk)
+}
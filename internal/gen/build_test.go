@@ -0,0 +1,46 @@
+package gen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParallelBuild(t *testing.T) {
+	// Tasks read the output while others write to it. Run with -race.
+	fsys := fstest.MapFS{
+		"__src/" + TEMPLATE:   {Data: []byte("<html>{{.Body}}</html>\n")},
+		"__src/" + MDTEMPLATE: {Data: []byte("<h1>{{.Title}}</h1>\n{{.Body}}")},
+	}
+	for i := 0; i < 50; i++ {
+		fsys[fmt.Sprintf("__src/page%d.md", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("---\ntitle: Page %d\n---\nHello\n", i))}
+		fsys[fmt.Sprintf("__src/POSTS/2020/post%d/index.md", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("---\ntitle: Post %d\ndate: 2020-01-01\n---\nHello\n", i))}
+	}
+	site, err := LoadSite(fsys, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := NewMemOutput()
+	// The second build reads the files of the first one.
+	for run := 0; run < 2; run++ {
+		b := NewBuild(fsys, out, site, false, NewTextLogger(io.Discard, LevelError))
+		b.SetWorkers(8)
+		result := b.Run(context.Background(), ".")
+		if err := result.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("page%d.html", i)
+		want := fmt.Sprintf("<html><h1>Page %d</h1>\n<p>Hello</p>\n</html>\n", i)
+		if data, err := out.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+		post := fmt.Sprintf("posts/2020/post%d/index.html", i)
+		if _, err := out.ReadFile(post); err != nil {
+			t.Errorf("%s: %s", post, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package gen
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Checking a site generates it into memory and compares the generated files
+// with the files in the output, without writing anything.
+
+type Difference struct {
+	File string
+	// Content of the file in the output, nil if it is missing.
+	Output []byte
+	// Content of the file as generated, nil if the build would remove it.
+	Generated []byte
+}
+
+// Diff returns the difference as a unified diff from the output to the
+// generated file.
+
+func (d Difference) Diff() string {
+	from, to := "a/"+d.File, "b/"+d.File
+	if d.Output == nil {
+		from = "/dev/null"
+	}
+	if d.Generated == nil {
+		to = "/dev/null"
+	}
+	return UnifiedDiff(from, to, d.Output, d.Generated)
+}
+
+// Compare returns the differences between the files generated into mem
+// and the files in out, by file name. The files of prev, the manifest of
+// the last build into out, that would not be generated again are
+// differences too, as the build would remove them.
+
+func Compare(out ReadableOutput, mem *MemOutput, prev Manifest) ([]Difference, error) {
+	result := make([]Difference, 0)
+	for _, name := range mem.Names() {
+		if name == MANIFEST {
+			continue
+		}
+		generated := mem.Files[name]
+		if generated == nil {
+			generated = []byte{}
+		}
+		data, err := out.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			result = append(result, Difference{name, nil, generated})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if string(data) != string(generated) {
+			result = append(result, Difference{name, data, generated})
+		}
+	}
+	for _, e := range prev.Files {
+		if _, ok := mem.Files[e.File]; ok {
+			continue
+		}
+		data, err := out.ReadFile(e.File)
+		if err != nil || hashOf(data) != e.Hash {
+			// Gone, or modified by hand and kept.
+			continue
+		}
+		result = append(result, Difference{e.File, data, nil})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].File < result[j].File })
+	return result, nil
+}
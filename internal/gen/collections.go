@@ -0,0 +1,234 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A collection is a folder of items inside a __src folder, such as POSTS.
+// Every subfolder (at any depth) containing an index.md file is an item.
+// Items with `draft: true` in their front matter are left out, unless the
+// site is built with drafts.
+// Items are copied to the output folder of the collection next to the
+// __src folder, and a summary of all items is generated from a summary
+// template into the __src folder.
+//
+// Collections are declared in the configuration file:
+//
+//   [collections.TALKS]
+//   output = "talks"                     # default: name in lowercase
+//   permalink = ":year/:slug"            # default: ":key"
+//   sort = "date"                        # date (most recent first), title, key, weight; prefix with - to reverse
+//   layout = "talk"                      # default layout for items
+//   summary = "SUMMARY.talks.template"   # default: SUMMARY.<output>.template
+//   index = "talks.content"              # default: <output>.content
+//   redirects = "htaccess"               # also write redirects for aliases to .htaccess (see redirects.go)
+//
+// Collection POSTS always exists, and defaults to output "posts",
+// summary SUMMARY.template and index index.content.
+
+type Collection struct {
+	Name      string `toml:"-"`
+	Output    string `toml:"output"`
+	Permalink string `toml:"permalink"`
+	Sort      string `toml:"sort"`
+	Layout    string `toml:"layout"`
+	Summary   string `toml:"summary"`
+	Index     string `toml:"index"`
+	Redirects string `toml:"redirects"`
+}
+
+func (config Config) AllCollections() []Collection {
+	// All collections, with defaults filled in, ordered by name.
+	result := make([]Collection, 0, len(config.Collections)+1)
+	if _, ok := config.Collections[GENPOSTS]; !ok {
+		result = append(result, defaultCollection(GENPOSTS, Collection{}))
+	}
+	for name, coll := range config.Collections {
+		result = append(result, defaultCollection(name, coll))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func defaultCollection(name string, coll Collection) Collection {
+	coll.Name = name
+	if coll.Output == "" {
+		if name == GENPOSTS {
+			coll.Output = POSTDIR
+		} else {
+			coll.Output = strings.ToLower(name)
+		}
+	}
+	if coll.Permalink == "" {
+		coll.Permalink = ":key"
+	}
+	if coll.Sort == "" {
+		coll.Sort = "date"
+	}
+	if coll.Summary == "" {
+		if name == GENPOSTS {
+			coll.Summary = SUMMARYTEMPLATE
+		} else {
+			coll.Summary = strings.TrimSuffix(SUMMARYTEMPLATE, ".template") + "." + coll.Output + ".template"
+		}
+	}
+	if coll.Index == "" {
+		if name == GENPOSTS {
+			coll.Index = "index.content"
+		} else {
+			coll.Index = coll.Output + ".content"
+		}
+	}
+	return coll
+}
+
+// ExtractCollection returns all items of a collection folder, including drafts.
+
+func ExtractCollection(fsys fs.FS, dir string, coll Collection) ([]PostInfo, error) {
+	return extractCollection(newSourceFiles(fsys), dir, coll, true)
+}
+
+// ItemKey returns the key that item would have if its source folder were source.
+
+func ItemKey(coll Collection, item PostInfo, source string) string {
+	item.Source = source
+	item.Year = itemYear(source, item.Date)
+	return expandPermalink(coll.Permalink, item)
+}
+
+func extractCollection(sources *sourceFiles, dir string, coll Collection, drafts bool) ([]PostInfo, error) {
+	items := make([]PostInfo, 0)
+	if err := extractItems(sources, dir, "", coll, drafts, &items); err != nil {
+		return nil, err
+	}
+	if err := sortItems(items, coll.Sort); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func extractItems(sources *sourceFiles, dir string, source string, coll Collection, drafts bool, items *[]PostInfo) error {
+	entries, err := fs.ReadDir(sources.fsys, path.Join(dir, source))
+	if err != nil {
+		return err
+	}
+	for _, d := range entries {
+		if !d.IsDir() || isGenDir(d.Name()) {
+			continue
+		}
+		itemSource := path.Join(source, d.Name())
+		md, err := sources.load(path.Join(dir, itemSource, POSTMD))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Not an item, but may contain items (e.g., a year folder).
+			if err := extractItems(sources, dir, itemSource, coll, drafts, items); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		metadata := md.metadata
+		item := PostInfo{metadata.Title, metadata.Date, metadata.Reading, "", itemYear(itemSource, metadata.Date), metadata.Params, itemSource}
+		if item.IsDraft() && !drafts {
+			continue
+		}
+		item.Key = expandPermalink(coll.Permalink, item)
+		*items = append(*items, item)
+	}
+	return nil
+}
+
+func itemYear(source string, date time.Time) int {
+	// The year folder of the item if there is one, otherwise the year of its date.
+	first := strings.Split(source, "/")[0]
+	if year, err := strconv.Atoi(first); err == nil {
+		return year
+	}
+	if date.IsZero() {
+		return 0
+	}
+	return date.Year()
+}
+
+func expandPermalink(pattern string, item PostInfo) string {
+	replacements := []string{
+		":key", item.Source,
+		":slug", path.Base(item.Source),
+		":title", Slugify(item.Title),
+		":year", fmt.Sprintf("%04d", item.Year),
+		":month", fmt.Sprintf("%02d", int(item.Date.Month())),
+		":day", fmt.Sprintf("%02d", item.Date.Day()),
+	}
+	if item.Date.IsZero() {
+		replacements[9] = "00"
+		replacements[11] = "00"
+	}
+	return strings.NewReplacer(replacements...).Replace(pattern)
+}
+
+func sortItems(items []PostInfo, order string) error {
+	reverse := strings.HasPrefix(order, "-")
+	var less func(i, j int) bool
+	switch strings.TrimPrefix(order, "-") {
+	case "date":
+		// Most recent first.
+		less = func(i, j int) bool { return items[i].Date.After(items[j].Date) }
+	case "title":
+		less = func(i, j int) bool { return items[i].Title < items[j].Title }
+	case "key":
+		less = func(i, j int) bool { return items[i].Key < items[j].Key }
+	case "weight":
+		less = func(i, j int) bool { return itemWeight(items[i]) < itemWeight(items[j]) }
+	default:
+		return fmt.Errorf("unknown sort order %s", order)
+	}
+	// Break ties by key so that the order is deterministic.
+	sort.SliceStable(items, func(i, j int) bool {
+		if reverse {
+			i, j = j, i
+		}
+		if less(i, j) {
+			return true
+		}
+		if less(j, i) {
+			return false
+		}
+		return items[i].Key < items[j].Key
+	})
+	return nil
+}
+
+func itemWeight(item PostInfo) float64 {
+	weight, err := strconv.ParseFloat(item.Params["weight"], 64)
+	if err != nil {
+		return 0
+	}
+	return weight
+}
+
+func Slugify(title string) string {
+	// Lowercase letters and digits, with words separated by dashes.
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(title) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// A build never overwrites a file it does not own: two sources generating
+// the same file is an error, and so is generating a file over a file that
+// the previous build did not generate, e.g., a hand-written .html file.
+// The files of the previous build are known from its manifest; without a
+// manifest (e.g., for sites built before manifests existed), overwriting a
+// file with different content is only a warning.
+
+type ownership struct {
+	mu sync.Mutex
+	// Files and folders of the previous build into the output, nil if unknown.
+	files   map[string]bool
+	folders map[string]bool
+	// Files generated into the source folder by a previous build in place,
+	// nil if unknown. Only used when not building in place.
+	sources map[string]bool
+	// Folders generated as a whole by the build.
+	staged []string
+	// Files generated by the build, with their source.
+	claims map[string]string
+	// Files added by hand to folders generated as a whole, which are kept.
+	kept map[string]bool
+}
+
+func newOwnership() *ownership {
+	return &ownership{sync.Mutex{}, nil, nil, nil, make([]string, 0), make(map[string]string), make(map[string]bool)}
+}
+
+func (b *Build) loadOwnership(prev Manifest, found bool) {
+	if found {
+		b.own.files = make(map[string]bool)
+		b.own.folders = make(map[string]bool)
+		for _, e := range prev.Files {
+			b.own.files[e.File] = true
+		}
+		for _, e := range prev.Folders {
+			b.own.folders[e.File] = true
+		}
+	}
+	if b.inPlace {
+		return
+	}
+	data, err := fs.ReadFile(b.fsys, MANIFEST)
+	if err != nil {
+		return
+	}
+	sources, err := parseManifest(data)
+	if err != nil {
+		return
+	}
+	b.own.sources = make(map[string]bool)
+	for _, e := range sources.Files {
+		b.own.sources[e.File] = true
+	}
+}
+
+func (o *ownership) owns(name string) bool {
+	// Whether name was generated by the previous build, or is in a folder generated as a whole.
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.kept[name] {
+		return false
+	}
+	if o.files[name] {
+		return true
+	}
+	for folder := range o.folders {
+		if isUnder(name, folder) {
+			return true
+		}
+	}
+	for _, folder := range o.staged {
+		if isUnder(name, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ownership) claim(name string, src string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if other, ok := o.claims[name]; ok && other != src {
+		return fmt.Errorf("%s is generated from both %s and %s", name, other, src)
+	}
+	o.claims[name] = src
+	return nil
+}
+
+func (o *ownership) keep(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.kept[name] = true
+}
+
+func (o *ownership) stage(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.staged = append(o.staged, name)
+}
+
+func (b *Build) checkWrite(name string, src string, data []byte) error {
+	// Check that the build can write name, generated from src.
+	if err := b.own.claim(name, src); err != nil {
+		return err
+	}
+	if b.own.owns(name) {
+		return nil
+	}
+	var existing []byte
+	var err error
+	known := b.own.files
+	if b.isOutput(name) {
+		out, ok := b.out.Output.(ReadableOutput)
+		if !ok {
+			return nil
+		}
+		existing, err = out.ReadFile(name)
+	} else {
+		// Files generated into __src folders only overwrite the sources in memory,
+		// but they hide them from the build.
+		if b.own.sources[name] {
+			return nil
+		}
+		existing, err = fs.ReadFile(b.fsys.base, name)
+		known = b.own.sources
+	}
+	if err != nil || bytes.Equal(existing, data) {
+		return nil
+	}
+	return b.notOwned("file", name, src, known != nil)
+}
+
+func (b *Build) checkStage(name string, src string) error {
+	// Check that the build can replace folder name, generated from collection src.
+	if err := b.own.claim(name, src); err != nil {
+		return err
+	}
+	if b.own.folders[name] {
+		b.own.stage(name)
+		return nil
+	}
+	exists := false
+	if out, ok := b.out.Output.(ReadableOutput); ok {
+		if _, err := out.Stat(name); err == nil {
+			exists = true
+		}
+	}
+	if exists {
+		if err := b.notOwned("folder", name, src, b.own.folders != nil); err != nil {
+			return err
+		}
+	}
+	b.own.stage(name)
+	return nil
+}
+
+func (b *Build) handFiles(name string, src string) []string {
+	// The files of folder name that the previous build did not generate,
+	// when the folder was generated as a whole. They were added by hand,
+	// and are kept when the folder is regenerated.
+	out, ok := b.out.Output.(ReadableOutput)
+	if !ok || !b.own.folders[name] {
+		return nil
+	}
+	result := make([]string, 0)
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, err := out.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, d := range entries {
+			fname := path.Join(dir, d.Name())
+			if strings.HasPrefix(d.Name(), tempPrefix) {
+				continue
+			}
+			if d.IsDir() {
+				walk(fname)
+				continue
+			}
+			if !b.own.files[fname] {
+				b.log.Log(LevelWarning, "keeping a file that was not generated by webgen", Fields{"file": fname, "source": src})
+				b.own.keep(fname)
+				result = append(result, fname)
+			}
+		}
+	}
+	walk(name)
+	return result
+}
+
+func (b *Build) notOwned(kind string, name string, src string, known bool) error {
+	// Whether the previous build generated name is known from its manifest, or not.
+	if known {
+		return fmt.Errorf("%s %s was not generated by webgen, not replacing it with the output of %s", kind, name, src)
+	}
+	b.log.Log(LevelWarning, "replacing a "+kind+" that may not have been generated by webgen", Fields{"file": name, "source": src})
+	return nil
+}
+
+func (b *Build) checkStatic(name string) error {
+	// A static file with the name of a generated file is not copied, which is an error
+	// unless it was generated by a previous build in place.
+	if b.own.sources[name] {
+		return nil
+	}
+	existing, err := fs.ReadFile(b.fsys.base, name)
+	if err != nil {
+		return nil
+	}
+	generated, err := b.fsys.ReadFile(name)
+	if err != nil || bytes.Equal(existing, generated) {
+		return nil
+	}
+	if b.own.sources != nil {
+		return fmt.Errorf("%s is both a static file and a generated file", name)
+	}
+	b.log.Log(LevelWarning, "static file replaced by a generated file", Fields{"file": name})
+	return nil
+}
@@ -0,0 +1,57 @@
+package gen
+
+import (
+	"errors"
+	"github.com/BurntSushi/toml"
+	"io/fs"
+)
+
+// The site configuration is read from webgen.toml at the root of the site:
+//
+//   title = "My site"
+//   baseurl = "https://example.com"
+//   author = "Jane Doe"
+//   browser = "firefox %s"   # command opening drafts, %s is the file or URL
+//
+//   [params]
+//   twitter = "@jdoe"
+//
+// Collections are described in collections.go.
+
+type Config struct {
+	Title   string                 `toml:"title"`
+	BaseURL string                 `toml:"baseurl"`
+	Author  string                 `toml:"author"`
+	Browser string                 `toml:"browser"`
+	Params  map[string]interface{} `toml:"params"`
+	// Drafts tells whether to build items with `draft: true`, which are left out by default.
+	Drafts bool `toml:"-"`
+
+	Collections map[string]Collection `toml:"collections"`
+}
+
+func LoadConfig(fname string) (Config, error) {
+	// A configuration file given explicitly must exist.
+	config := Config{}
+	if _, err := toml.DecodeFile(fname, &config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+func LoadConfigFS(fsys fs.FS, name string) (Config, error) {
+	// Same as LoadConfig, reading from a file system. A missing file is not
+	// an error, since the default configuration file is optional.
+	config := Config{}
+	src, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	if _, err := toml.Decode(string(src), &config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
@@ -0,0 +1,47 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, CONFIGFILE)
+	if err := os.WriteFile(fname, []byte("title = \"My site\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Title != "My site" {
+		t.Errorf("LoadConfig(%q): got title %q, want %q", fname, config.Title, "My site")
+	}
+	// A configuration file given explicitly must exist.
+	missing := filepath.Join(dir, "missing.toml")
+	if _, err := LoadConfig(missing); err == nil {
+		t.Errorf("LoadConfig(%q): got no error for a missing file", missing)
+	}
+}
+
+func TestLoadConfigFS(t *testing.T) {
+	fsys := fstest.MapFS{CONFIGFILE: {Data: []byte("title = \"My site\"\n")}}
+	config, err := LoadConfigFS(fsys, CONFIGFILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Title != "My site" {
+		t.Errorf("LoadConfigFS: got title %q, want %q", config.Title, "My site")
+	}
+	// The default configuration file is optional.
+	config, err = LoadConfigFS(fstest.MapFS{}, CONFIGFILE)
+	if err != nil {
+		t.Errorf("LoadConfigFS without a configuration file: %s", err)
+	}
+	if config.Title != "" {
+		t.Errorf("LoadConfigFS without a configuration file: got title %q", config.Title)
+	}
+}
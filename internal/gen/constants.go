@@ -8,3 +8,8 @@ const GENDIR = "__src" // Can also have a leading .
 const GENPOSTS = "POSTS"
 const POSTMD = "index.md"
 const POSTDIR = "posts"
+const SHORTCODEDIR = "shortcodes"
+const DATADIR = "data"
+const CONFIGFILE = "webgen.toml"
+const MANIFEST = ".webgen-manifest.json" // At the root of the output
+const ARCHETYPE = "ARCHETYPE.template"
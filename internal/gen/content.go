@@ -1,13 +1,13 @@
 package gen
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/russross/blackfriday/v2"
 	"html/template"
 	"io"
-	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
 	"time"
 )
@@ -18,31 +18,71 @@ type Content struct {
 	FormattedDate string
 	Reading       string
 	Key           string
-	Body          template.HTML
+	// Params holds the front matter fields of the page, including the ones above.
+	Params map[string]string
+	Body   template.HTML
+	Site   *Site
+	// Data is the content of the data files visible from the page.
+	Data map[string]interface{}
+	// Page is the page in .Site, with its links to other pages.
+	Page *PageInfo
 }
 
-// Exists here and in main. Why?
-var rep *log.Logger = log.New(os.Stdout, "" /* log.Ldate| */, log.Ltime)
+func (b *Build) source(fname string) (*sourceFile, error) {
+	// Sources were read when loading the site, except generated ones.
+	if b.fsys.isGenerated(fname) {
+		src, err := fs.ReadFile(b.fsys, fname)
+		if err != nil {
+			return nil, err
+		}
+		return parseSource(src)
+	}
+	return b.site.sources.load(fname)
+}
+
+func (b *Build) page(fname string) *PageInfo {
+	// The page generated from a source file in a __src folder.
+	name := path.Base(fname)
+	if IsMarkdown(name) {
+		name = targetFilename(name, "md", "html")
+	} else {
+		name = targetFilename(name, "content", "html")
+	}
+	return b.site.Page(siteURL(path.Join(path.Dir(path.Dir(fname)), name)))
+}
 
-func ProcessFileContent(w io.Writer, fname string) error {
-	rep.Printf("%s\n", fname)
-	templates, err := findTemplate(fname)
+func (b *Build) ProcessFileContent(w io.Writer, fname string) error {
+	b.log.Log(LevelVerbose, "processing", Fields{"file": fname, "phase": PhaseContent})
+	source, err := b.source(fname)
 	if err != nil {
 		return err
 	}
-	if len(templates) == 0 {
-		return fmt.Errorf("No template found")
+	metadata, body := source.metadata, source.body
+	data, err := b.LoadData(fname)
+	if err != nil {
+		return err
 	}
-	main, err := ioutil.ReadFile(fname)
+	templates, err := b.findTemplate(fname, metadata.Layout())
 	if err != nil {
 		return err
 	}
-	current := template.HTML(main)
+	if len(templates) == 0 {
+		return fmt.Errorf("No template found")
+	}
+	if isTrue(metadata.Params["markdown"]) {
+		// Body is markdown rather than HTML.
+		body, err = b.ExpandShortcodes(fname, body)
+		if err != nil {
+			return err
+		}
+		body = blackfriday.Run(body, blackfriday.WithNoExtensions())
+	}
+	current := template.HTML(body)
 	for _, tinfo := range templates {
 		tpl := tinfo.template
 		tname := tinfo.name
-		rep.Printf("  using template %s\n", tname)
-		c := Content{"", time.Time{}, "", "", "", current}
+		b.log.Log(LevelVerbose, "using template", Fields{"file": fname, "template": tname})
+		c := Content{metadata.Title, metadata.Date, FormatDate(metadata.Date), metadata.Reading, "", metadata.Params, current, b.site, data, b.page(fname)}
 		current, err = ProcessTemplate(tpl, c)
 		if err != nil {
 			return err
@@ -69,63 +109,160 @@ type template_info struct {
 	name     string
 }
 
-func findTemplate(path string) ([]template_info, error) {
-	// Given a path, find the nearest enclosing _gentemplate file.
-	// If encountering _gentemplate_sub file, add to list but continue looking.
+// TemplateFile is a template file that applies to a page, with the template
+// files it extends, nearest first.
+
+type TemplateFile struct {
+	Name    string
+	Extends []string
+}
+
+// Templates returns the template files that apply to a .content or .md file,
+// in the order in which they apply: for a .md file, the markdown template if
+// there is one, and then the templates of the generated .content file.
+
+func (b *Build) Templates(fname string) ([]TemplateFile, error) {
+	src, err := fs.ReadFile(b.fsys, fname)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _, err := ExtractMetadata(src)
+	if err != nil {
+		return nil, err
+	}
+	return b.templateFiles(fname, metadata.Layout())
+}
+
+func (b *Build) templateFiles(fname string, layout string) ([]TemplateFile, error) {
+	result := make([]TemplateFile, 0)
+	if IsMarkdown(fname) {
+		b.log.Log(LevelDebug, "looking for markdown template", layoutFields(fname, layout))
+		_, tname, err := b.FindMarkdownTemplate(fname, layout)
+		if err != nil {
+			return nil, err
+		}
+		if tname != "" {
+			result = append(result, TemplateFile{tname, b.res.extends(tname)})
+		}
+	} else if !IsContent(fname) {
+		return nil, fmt.Errorf("%s is neither a .content nor a .md file", fname)
+	}
+	b.log.Log(LevelDebug, "looking for content templates", layoutFields(fname, layout))
+	templates, err := b.findTemplate(fname, layout)
+	if err != nil {
+		return nil, err
+	}
+	for _, tinfo := range templates {
+		result = append(result, TemplateFile{tinfo.name, b.res.extends(tinfo.name)})
+	}
+	return result, nil
+}
+
+func layoutFields(fname string, layout string) Fields {
+	if layout == "" {
+		return Fields{"file": fname}
+	}
+	return Fields{"file": fname, "layout": layout}
+}
+
+func (b *Build) findTemplate(fname string, layout string) ([]template_info, error) {
+	// If a layout is given, look for the nearest <layout>.template in place
+	// of CONTENT.template, falling back to CONTENT.template if there is none.
+	if layout != "" && isTemplateName(layout) {
+		result, err := b.findTemplateChain(fname, layoutTemplate(layout))
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+		b.log.Log(LevelDebug, "no template found for layout", Fields{"file": fname, "layout": layout})
+	}
+	result, err := b.findTemplateChain(fname, TEMPLATE)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no template found")
+	}
+	return result, nil
+}
+
+func (b *Build) findTemplateChain(fname string, top string) ([]template_info, error) {
+	// Given a path, find the nearest enclosing top template file.
+	// If encountering SUB.template file, add to list but continue looking.
+	// Returns nil if there is no top template file.
 	result := make([]template_info, 0)
-	previous, _ := filepath.Abs(path)
-	current := filepath.Dir(previous)
-	for current != previous {
-		gdPath, err := identifyGenDirPath(current)
-		if err == nil {
-			subtname := filepath.Join(gdPath, SUBTEMPLATE)
-			subtpl, err := template.ParseFiles(subtname)
-			if err == nil {
+	for _, dir := range parentDirs(fname) {
+		if gdPath, ok := b.searchDir(dir); ok {
+			subtpl, subtname, err := b.findTemplateFile(gdPath, SUBTEMPLATE)
+			if err != nil {
+				return nil, err
+			}
+			if subtpl != nil {
 				result = append(result, template_info{subtpl, subtname})
 			}
-			tname := filepath.Join(gdPath, TEMPLATE)
-			tpl, err := template.ParseFiles(tname)
-			if err == nil {
+			tpl, tname, err := b.findTemplateFile(gdPath, top)
+			if err != nil {
+				return nil, err
+			}
+			if tpl != nil {
 				result = append(result, template_info{tpl, tname})
 				return result, nil
 			}
 		}
-		previous = current
-		current = filepath.Dir(current)
 	}
-	return nil, fmt.Errorf("no template found")
+	return nil, nil
+}
+
+func layoutTemplate(layout string) string {
+	return layout + ".template"
+}
+
+func isTemplateName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
 }
 
-func ProcessFilesContent(cwd string, path string) {
-	genDir, err := identifyGenDir(path)
+func (b *Build) contentTasks(dir string) []task {
+	// One task per .content file in the __src folder of dir.
+	genDir, err := b.res.genDir(dir)
 	if err != nil {
-		return
+		return nil
 	}
-	entries, err := os.ReadDir(filepath.Join(path, genDir))
+	entries, err := fs.ReadDir(b.fsys, path.Join(dir, genDir))
 	if err != nil {
 		// if we can't read GENDIR, skip.
-		return
+		return nil
 	}
+	tasks := make([]task, 0)
 	for _, d := range entries {
 		if !d.IsDir() && IsContent(d.Name()) {
-			relPath, err := filepath.Rel(cwd, path)
-			if err != nil {
-				relPath = path
-			}
-			target := filepath.Join(relPath, targetFilename(d.Name(), "content", "html"))
-			w, err := os.Create(target)
-			if err != nil {
-				w.Close()
-				rep.Printf("ERROR: %s\n", err)
-				continue
-			}
-			if err := ProcessFileContent(w, filepath.Join(relPath, genDir, d.Name())); err != nil {
-				w.Close()
-				rep.Printf("ERROR: %s\n", err)
-				continue
-			}
-			rep.Printf("  wrote %s", target)
-			w.Close()
+			src := path.Join(dir, genDir, d.Name())
+			target := path.Join(dir, targetFilename(d.Name(), "content", "html"))
+			tasks = append(tasks, func(t *Build) { t.generateContent(src, target) })
 		}
 	}
+	return tasks
+}
+
+func (b *Build) generateContent(src string, target string) {
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := b.ProcessFileContent(&buf, src); err != nil {
+		b.reportError(src, PhaseContent, err)
+		return
+	}
+	if err := b.writeFile(target, src, PhaseContent, buf.Bytes()); err != nil {
+		b.reportError(src, PhaseContent, err)
+		return
+	}
+	b.reportWritten(target, src, b.templatesOf(src, PhaseContent), PhaseContent, start)
 }
@@ -0,0 +1,136 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Data files live in the data/ folder of a __src folder, and are available
+// as .Data in templates, keyed by file name without extension.
+// Subfolders of data/ give nested maps.
+// The data/ folders of all enclosing __src folders are merged, with
+// nearer folders shadowing farther ones.
+
+func (b *Build) LoadData(fname string) (map[string]interface{}, error) {
+	// Collect the data folders from nearest to farthest.
+	dataDirs := make([]string, 0)
+	for _, dir := range parentDirs(fname) {
+		gdPath, err := b.res.genDirPath(dir)
+		if err == nil {
+			dataDir := path.Join(gdPath, DATADIR)
+			if fi, err := fs.Stat(b.fsys, dataDir); err == nil && fi.IsDir() {
+				dataDirs = append(dataDirs, dataDir)
+			}
+		}
+	}
+	result := make(map[string]interface{})
+	for i := len(dataDirs) - 1; i >= 0; i-- {
+		// Data folders were read when loading the site, except generated ones.
+		data, ok := b.site.data[dataDirs[i]]
+		if !ok {
+			var err error
+			data, err = loadDataDir(b.fsys, dataDirs[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		for k, v := range data {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func loadDataDir(fsys fs.FS, dir string) (map[string]interface{}, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	for _, d := range entries {
+		fname := path.Join(dir, d.Name())
+		if d.IsDir() {
+			data, err := loadDataDir(fsys, fname)
+			if err != nil {
+				return nil, err
+			}
+			result[d.Name()] = data
+			continue
+		}
+		ext := path.Ext(d.Name())
+		if !isDataFile(d.Name()) {
+			continue
+		}
+		data, err := loadDataFile(fsys, fname)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fname, err)
+		}
+		result[strings.TrimSuffix(d.Name(), ext)] = data
+	}
+	return result, nil
+}
+
+func isDataFile(fname string) bool {
+	switch strings.ToLower(path.Ext(fname)) {
+	case ".json", ".yaml", ".yml", ".toml", ".csv":
+		return true
+	}
+	return false
+}
+
+func loadDataFile(fsys fs.FS, fname string) (interface{}, error) {
+	src, err := fs.ReadFile(fsys, fname)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	switch strings.ToLower(path.Ext(fname)) {
+	case ".json":
+		if err := json.Unmarshal(src, &data); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(src, &data); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		table := make(map[string]interface{})
+		if _, err := toml.Decode(string(src), &table); err != nil {
+			return nil, err
+		}
+		data = table
+	case ".csv":
+		return loadCSV(src)
+	}
+	return data, nil
+}
+
+func loadCSV(src []byte) ([]map[string]string, error) {
+	// The first row gives the field names.
+	records, err := csv.NewReader(bytes.NewReader(src)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]string, 0)
+	if len(records) == 0 {
+		return result, nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, field := range record {
+			if i < len(header) {
+				row[header[i]] = field
+			}
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
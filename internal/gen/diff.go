@@ -0,0 +1,184 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unified diffs between two versions of a file, by lines, as printed by
+// `diff -u`. Lines are matched using Myers' algorithm.
+
+const diffContext = 3
+
+// Beyond this number of differences, a file is shown as entirely replaced.
+const maxDiffEdits = 2000
+
+type diffLine struct {
+	// One of ' ', '-', '+'.
+	op   byte
+	text string
+}
+
+// UnifiedDiff returns the differences between a and b, named aName and bName,
+// or "" if there are none.
+
+func UnifiedDiff(aName string, bName string, a []byte, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	if isBinary(a) || isBinary(b) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", aName, bName)
+	}
+	lines := diffLines(splitLines(a), splitLines(b))
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	// Line numbers in a and b at the start of each line of the diff.
+	aNums := make([]int, len(lines)+1)
+	bNums := make([]int, len(lines)+1)
+	for i, l := range lines {
+		aNums[i+1], bNums[i+1] = aNums[i], bNums[i]
+		if l.op != '+' {
+			aNums[i+1]++
+		}
+		if l.op != '-' {
+			bNums[i+1]++
+		}
+	}
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// A hunk extends until the changes are more than twice the context apart.
+		end := start + 1
+		for end < len(lines) {
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next + 1
+		}
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(lines) {
+			to = len(lines)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aNums[from], aNums[to]-aNums[from]), hunkRange(bNums[from], bNums[to]-bNums[from]))
+		for _, l := range lines[from:to] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return out.String()
+}
+
+func hunkRange(start int, n int) string {
+	// Lines are numbered from 1; an empty range starts at the line before it.
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func diffLines(a []string, b []string) []diffLine {
+	// Find the furthest reaching paths with d differences, for increasing d,
+	// keeping them to trace back the shortest edit script.
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	trace := make([][]int, 0)
+	done := false
+	for d := 0; d <= n+m && d <= maxDiffEdits; d++ {
+		// Only diagonals -d to d are reached so far.
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+	result := make([]diffLine, 0, n+m)
+	if !done {
+		for _, line := range a {
+			result = append(result, diffLine{'-', line})
+		}
+		for _, line := range b {
+			result = append(result, diffLine{'+', line})
+		}
+		return result
+	}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prevK := k - 1
+			if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+				prevK = k + 1
+			}
+			prevX = v[d+prevK]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			result = append(result, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				result = append(result, diffLine{'+', b[y-1]})
+			} else {
+				result = append(result, diffLine{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
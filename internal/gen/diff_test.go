@@ -0,0 +1,53 @@
+package gen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numbered(changes map[int]string) string {
+	// Lines l1 to l20, with some lines changed.
+	var b strings.Builder
+	for i := 1; i <= 20; i++ {
+		if line, ok := changes[i]; ok {
+			b.WriteString(line + "\n")
+		} else {
+			fmt.Fprintf(&b, "l%d\n", i)
+		}
+	}
+	return b.String()
+}
+
+// The expected diffs are the output of GNU diff -u.
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{"same", "x\ny\n", "x\ny\n", ""},
+		{"one change", numbered(nil), numbered(map[int]string{10: "X10"}), "--- a\n+++ b\n@@ -7,7 +7,7 @@\n l7\n l8\n l9\n-l10\n+X10\n l11\n l12\n l13\n"},
+		{"changes six lines apart share a hunk", numbered(nil), numbered(map[int]string{4: "X4", 11: "X11"}), "--- a\n+++ b\n@@ -1,14 +1,14 @@\n l1\n l2\n l3\n-l4\n+X4\n l5\n l6\n l7\n l8\n l9\n l10\n-l11\n+X11\n l12\n l13\n l14\n"},
+		{"changes seven lines apart", numbered(nil), numbered(map[int]string{4: "X4", 12: "X12"}), "--- a\n+++ b\n@@ -1,7 +1,7 @@\n l1\n l2\n l3\n-l4\n+X4\n l5\n l6\n l7\n@@ -9,7 +9,7 @@\n l9\n l10\n l11\n-l12\n+X12\n l13\n l14\n l15\n"},
+		{"newline added at end of file", "x\ny", "x\ny\n", "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+y\n"},
+		{"no newline at end of either file", "x\ny", "x\nz", "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+z\n\\ No newline at end of file\n"},
+		{"new file", "", "p\nq\n", "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+p\n+q\n"},
+		{"lines removed and added", "a\nb\nc\n", "b\nc\nd\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n-a\n b\n c\n+d\n"},
+		{"binary", "a\x00b", "a\x00c", "Binary files a and b differ\n"},
+	}
+	for _, test := range tests {
+		if got := UnifiedDiff("a", "b", []byte(test.a), []byte(test.b)); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnifiedDiffMissingFile(t *testing.T) {
+	want := "--- a/x\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-p\n-q\n"
+	if got := (Difference{"x", []byte("p\nq\n"), nil}).Diff(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package gen
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// An Explanation tells how the templates of a source file are found: the
+// steps of the lookup, as logged at the debug level during a build, and the
+// templates that apply, in order.
+
+type Explanation struct {
+	File string
+	// The file the templates are looked up from, when it is not File itself:
+	// the copy of a collection item, or the file generated from a collection.
+	Generated string
+	Layout    string
+	Steps     []string
+	Templates []TemplateFile
+}
+
+// Explain explains the templates of a .content or .md file, of a collection
+// item, or of a collection folder.
+
+func (b *Build) Explain(fname string) (*Explanation, error) {
+	trace := &logBuffer{}
+	logger := b.log
+	b.log = trace
+	defer func() { b.log = logger }()
+	result := &Explanation{fname, "", "", nil, nil}
+	if coll, ok := b.collectionOf(fname); ok {
+		// The summary is generated into the __src folder enclosing the collection,
+		// and then goes through the content templates.
+		result.Generated = path.Join(path.Dir(fname), coll.Index)
+		b.log.Log(LevelDebug, "looking for summary template", Fields{"file": fname, "template": coll.Summary})
+		_, tname, err := b.FindSummaryTemplate(fname, coll.Summary)
+		if err != nil {
+			return nil, err
+		}
+		if tname == "" {
+			return nil, fmt.Errorf("no summary template found for %s", fname)
+		}
+		templates, err := b.templateFiles(result.Generated, "")
+		if err != nil {
+			return nil, err
+		}
+		result.Templates = append([]TemplateFile{{tname, b.res.extends(tname)}}, templates...)
+	} else {
+		source, err := b.source(fname)
+		if err != nil {
+			return nil, err
+		}
+		result.Layout = source.metadata.Layout()
+		generated := fname
+		if page := b.itemOf(fname); page != nil {
+			// Items are copied to the output folder of their collection,
+			// with the default layout of the collection.
+			generated = path.Join(strings.TrimPrefix(path.Dir(page.URL), "/"), "."+GENDIR, POSTMD)
+			result.Generated = generated
+			if result.Layout == "" {
+				result.Layout = b.collection(page.Collection).Layout
+			}
+		}
+		templates, err := b.templateFiles(generated, result.Layout)
+		if err != nil {
+			return nil, err
+		}
+		result.Templates = templates
+	}
+	for _, e := range trace.entries {
+		result.Steps = append(result.Steps, FormatMessage(e.msg, e.fields))
+	}
+	return result, nil
+}
+
+func (b *Build) collectionOf(name string) (Collection, bool) {
+	// The collection whose source folder is name.
+	if fi, err := fs.Stat(b.fsys, name); err != nil || !fi.IsDir() {
+		return Collection{}, false
+	}
+	dir := path.Dir(path.Dir(name))
+	for _, coll := range b.site.collections {
+		if genColl, err := identifyGenCollection(b.fsys, dir, coll.Name); err == nil && path.Join(dir, genColl) == name {
+			return coll, true
+		}
+	}
+	return Collection{}, false
+}
+
+func (b *Build) itemOf(fname string) *PageInfo {
+	// The collection item whose source is fname, or nil.
+	for _, items := range b.site.Collections {
+		for i := range items {
+			if items[i].Source == fname {
+				return &items[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Build) collection(name string) Collection {
+	for _, coll := range b.site.collections {
+		if coll.Name == name {
+			return coll
+		}
+	}
+	return Collection{}
+}
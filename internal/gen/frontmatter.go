@@ -0,0 +1,78 @@
+package gen
+
+import (
+	"strings"
+)
+
+// Front matter fields are edited in place, line by line, so that the other
+// fields and the body are kept exactly as they are.
+
+func frontMatterLines(lines []string) (int, int, bool) {
+	// The lines of the opening and closing `---` of the front matter, as
+	// found by ExtractMetadata.
+	start := -1
+	for idx, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "---" && start >= 0:
+			return start, idx, true
+		case line == "---":
+			start = idx
+		case line != "" && start < 0:
+			// No front matter.
+			return 0, 0, false
+		}
+	}
+	return 0, 0, false
+}
+
+func fieldName(line string) string {
+	fields := strings.SplitN(line, ":", 2)
+	if len(fields) != 2 {
+		return ""
+	}
+	return strings.TrimSpace(fields[0])
+}
+
+// SetField sets field of the front matter of md to value, replacing the
+// first line of the field or adding it at the end of the front matter.
+
+func SetField(md []byte, field string, value string) []byte {
+	lines := strings.Split(string(md), "\n")
+	start, end, ok := frontMatterLines(lines)
+	if !ok {
+		return []byte("---\n" + field + ": " + value + "\n---\n" + string(md))
+	}
+	// Keep the line endings of the file.
+	eol := ""
+	if strings.HasSuffix(lines[start], "\r") {
+		eol = "\r"
+	}
+	for idx := start + 1; idx < end; idx++ {
+		if fieldName(lines[idx]) == field {
+			lines[idx] = field + ": " + value + eol
+			return []byte(strings.Join(lines, "\n"))
+		}
+	}
+	lines = append(lines[:end], append([]string{field + ": " + value + eol}, lines[end:]...)...)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// RemoveField removes the lines of field from the front matter of md.
+
+func RemoveField(md []byte, field string) []byte {
+	lines := strings.Split(string(md), "\n")
+	start, end, ok := frontMatterLines(lines)
+	if !ok {
+		return md
+	}
+	result := make([]string, 0, len(lines))
+	result = append(result, lines[:start+1]...)
+	for _, line := range lines[start+1 : end] {
+		if fieldName(line) != field {
+			result = append(result, line)
+		}
+	}
+	result = append(result, lines[end:]...)
+	return []byte(strings.Join(result, "\n"))
+}
@@ -0,0 +1,58 @@
+package gen
+
+import (
+	"testing"
+)
+
+func TestSetField(t *testing.T) {
+	tests := []struct {
+		md    string
+		field string
+		value string
+		want  string
+	}{
+		{"---\ntitle: A\ndate: 2020-01-01\n---\nBody\n", "date", "2024-05-01", "---\ntitle: A\ndate: 2024-05-01\n---\nBody\n"},
+		{"---\ntitle: A\n---\nBody\n", "draft", "true", "---\ntitle: A\ndraft: true\n---\nBody\n"},
+		{"---\r\ntitle: A\r\ndraft: false\r\n---\r\nBody\r\n", "draft", "true", "---\r\ntitle: A\r\ndraft: true\r\n---\r\nBody\r\n"},
+		{"---\r\ntitle: A\r\n---\r\nBody\r\n", "draft", "true", "---\r\ntitle: A\r\ndraft: true\r\n---\r\nBody\r\n"},
+		{"\n---\ntitle: A\n---\n", "draft", "true", "\n---\ntitle: A\ndraft: true\n---\n"},
+		{"---\n---\n", "draft", "true", "---\ndraft: true\n---\n"},
+		// Only the first line of the field is replaced.
+		{"---\ndate: 1\ndate: 2\n---\n", "date", "3", "---\ndate: 3\ndate: 2\n---\n"},
+		// Fields of a similar name and lines of the body are left alone.
+		{"---\ndrafts: x\n---\ndraft: no\n", "draft", "true", "---\ndrafts: x\ndraft: true\n---\ndraft: no\n"},
+		// Without front matter, one is added.
+		{"Body\n", "draft", "true", "---\ndraft: true\n---\nBody\n"},
+		{"", "draft", "true", "---\ndraft: true\n---\n"},
+	}
+	for _, test := range tests {
+		result := SetField([]byte(test.md), test.field, test.value)
+		if string(result) != test.want {
+			t.Errorf("SetField(%q, %q, %q) = %q, want %q", test.md, test.field, test.value, result, test.want)
+		}
+	}
+}
+
+func TestRemoveField(t *testing.T) {
+	tests := []struct {
+		md    string
+		field string
+		want  string
+	}{
+		{"---\ntitle: A\ndraft: true\n---\nBody\n", "draft", "---\ntitle: A\n---\nBody\n"},
+		{"---\r\ntitle: A\r\ndraft: true\r\n---\r\nBody\r\n", "draft", "---\r\ntitle: A\r\n---\r\nBody\r\n"},
+		{"---\ndraft: true\ndraft: false\n---\n", "draft", "---\n---\n"},
+		{"---\ntitle: A\n---\nBody\n", "draft", "---\ntitle: A\n---\nBody\n"},
+		// Lines of the body are left alone.
+		{"---\ndraft: true\n---\ndraft: true\n", "draft", "---\n---\ndraft: true\n"},
+		// Without front matter, nothing changes.
+		{"draft: true\n", "draft", "draft: true\n"},
+		{"", "draft", ""},
+	}
+	for _, test := range tests {
+		result := RemoveField([]byte(test.md), test.field)
+		if string(result) != test.want {
+			t.Errorf("RemoveField(%q, %q) = %q, want %q", test.md, test.field, result, test.want)
+		}
+	}
+}
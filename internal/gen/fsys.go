@@ -0,0 +1,235 @@
+package gen
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources are read through an fs.FS, with slash-separated paths relative
+// to the root of the site. During a build, files generated by earlier phases
+// (e.g., .content files generated from .md files) are read by later phases,
+// so the sources are overlaid with the files generated so far.
+// Tasks of a phase read and write the overlay concurrently.
+
+type overlayFS struct {
+	mu   sync.RWMutex
+	base fs.FS
+	// Files generated during the build.
+	files map[string][]byte
+	// Folders containing generated files, with the names of their entries
+	// holding generated files.
+	dirs map[string]map[string]bool
+	// Folders removed during the build. Generated files take precedence.
+	removed []string
+}
+
+func newOverlayFS(base fs.FS) *overlayFS {
+	return &overlayFS{sync.RWMutex{}, base, make(map[string][]byte), make(map[string]map[string]bool), make([]string, 0)}
+}
+
+func (o *overlayFS) add(name string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[name] = data
+	o.addDirs(name)
+}
+
+func (o *overlayFS) addDirs(name string) {
+	// Called with the lock held.
+	for name != "." {
+		dir := path.Dir(name)
+		if o.dirs[dir] == nil {
+			o.dirs[dir] = make(map[string]bool)
+		}
+		o.dirs[dir][path.Base(name)] = true
+		name = dir
+	}
+}
+
+func (o *overlayFS) remove(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for fname := range o.files {
+		if isUnder(fname, name) {
+			delete(o.files, fname)
+		}
+	}
+	o.dirs = make(map[string]map[string]bool)
+	for fname := range o.files {
+		o.addDirs(fname)
+	}
+	o.removed = append(o.removed, name)
+}
+
+// isRemoved and isGeneratedDir are called with the lock held.
+
+func (o *overlayFS) isRemoved(name string) bool {
+	for _, removed := range o.removed {
+		if isUnder(name, removed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *overlayFS) isGenerated(name string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	_, ok := o.files[name]
+	return ok
+}
+
+func (o *overlayFS) isGeneratedDir(name string) bool {
+	return o.dirs[name] != nil
+}
+
+func isUnder(name string, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if data, ok := o.files[name]; ok {
+		return &memFile{memFileInfo{path.Base(name), int64(len(data)), false}, strings.NewReader(string(data))}, nil
+	}
+	if o.isRemoved(name) {
+		if o.isGeneratedDir(name) {
+			return &memDir{memFileInfo{path.Base(name), 0, true}}, nil
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f, err := o.base.Open(name)
+	if err != nil && o.isGeneratedDir(name) {
+		return &memDir{memFileInfo{path.Base(name), 0, true}}, nil
+	}
+	return f, err
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	o.mu.RLock()
+	data, ok := o.files[name]
+	removed := o.isRemoved(name)
+	o.mu.RUnlock()
+	if ok {
+		return data, nil
+	}
+	if removed {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	// Read outside of the lock, so that reading from disk does not block the other tasks.
+	return fs.ReadFile(o.base, name)
+}
+
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if data, ok := o.files[name]; ok {
+		return memFileInfo{path.Base(name), int64(len(data)), false}, nil
+	}
+	if !o.isRemoved(name) {
+		fi, err := fs.Stat(o.base, name)
+		if err == nil {
+			return fi, nil
+		}
+	}
+	if o.isGeneratedDir(name) {
+		return memFileInfo{path.Base(name), 0, true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	entries := make(map[string]fs.DirEntry)
+	var baseErr error
+	if !o.isRemoved(name) {
+		baseEntries, err := fs.ReadDir(o.base, name)
+		baseErr = err
+		for _, d := range baseEntries {
+			if !o.isRemoved(path.Join(name, d.Name())) {
+				entries[d.Name()] = d
+			}
+		}
+	}
+	for entry := range o.dirs[name] {
+		if o.isGeneratedDir(path.Join(name, entry)) {
+			entries[entry] = fs.FileInfoToDirEntry(memFileInfo{entry, 0, true})
+		} else {
+			data := o.files[path.Join(name, entry)]
+			entries[entry] = fs.FileInfoToDirEntry(memFileInfo{entry, int64(len(data)), false})
+		}
+	}
+	if len(entries) == 0 && baseErr != nil {
+		return nil, baseErr
+	}
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, d := range entries {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+type memFile struct {
+	info   memFileInfo
+	reader *strings.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error)         { return f.info, nil }
+func (f *memFile) Read(b []byte) (int, error)         { return f.reader.Read(b) }
+func (f *memFile) Close() error                       { return nil }
+func (f *memFile) Seek(o int64, w int) (int64, error) { return f.reader.Seek(o, w) }
+
+type memDir struct {
+	info memFileInfo
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+func (d *memDir) Close() error { return nil }
+
+func parentDirs(name string) []string {
+	// The folders enclosing name, nearest first, up to the root ".".
+	dirs := make([]string, 0)
+	if name == "." {
+		return dirs
+	}
+	current := path.Dir(name)
+	for {
+		dirs = append(dirs, current)
+		if current == "." {
+			return dirs
+		}
+		current = path.Dir(current)
+	}
+}
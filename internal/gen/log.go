@@ -0,0 +1,181 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress and error messages go through a Logger. A message has a level
+// and structured fields such as the file being processed, the template
+// used, the phase of the build, or the time taken.
+// Loggers drop the messages above their level.
+
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarning
+	LevelInfo
+	LevelVerbose
+	LevelDebug
+)
+
+// A quiet logger only reports errors and warnings.
+const LevelQuiet = LevelWarning
+
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarning:
+		return "warning"
+	case LevelInfo:
+		return "info"
+	case LevelVerbose:
+		return "verbose"
+	case LevelDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("level%d", int(l))
+}
+
+type Fields map[string]interface{}
+
+type Logger interface {
+	Log(level Level, msg string, fields Fields)
+}
+
+// The default logger writes text to standard error.
+
+func DefaultLogger() Logger {
+	return NewTextLogger(os.Stderr, LevelInfo)
+}
+
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewTextLogger writes one line per message, with the fields as key=value.
+
+func NewTextLogger(w io.Writer, level Level) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+func (l *textLogger) Log(level Level, msg string, fields Fields) {
+	if level > l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(time.Now().Format("15:04:05 "))
+	switch level {
+	case LevelError:
+		b.WriteString("ERROR: ")
+	case LevelWarning:
+		b.WriteString("WARNING: ")
+	}
+	b.WriteString(FormatMessage(msg, fields))
+	b.WriteString("\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// FormatMessage formats a message as a text logger does, without the time.
+
+func FormatMessage(msg string, fields Fields) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(msg, "\n"))
+	for _, key := range fieldKeys(fields) {
+		value := fmt.Sprint(fieldValue(fields[key]))
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
+
+// ErrorsOnly passes on the errors logged to it, and drops other messages.
+
+func ErrorsOnly(logger Logger) Logger {
+	return errorsOnly{logger}
+}
+
+type errorsOnly struct {
+	logger Logger
+}
+
+func (l errorsOnly) Log(level Level, msg string, fields Fields) {
+	if level == LevelError {
+		l.logger.Log(level, msg, fields)
+	}
+}
+
+type jsonLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewJSONLogger writes one JSON object per message, with fields
+// time, level, msg, and the fields of the message.
+
+func NewJSONLogger(w io.Writer, level Level) Logger {
+	return &jsonLogger{w: w, level: level}
+}
+
+func (l *jsonLogger) Log(level Level, msg string, fields Fields) {
+	if level > l.level {
+		return
+	}
+	entry := make(map[string]interface{}, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = fieldValue(value)
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["msg"] = strings.TrimSuffix(msg, "\n")
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// Common fields come first in text messages, in this order, and duration last.
+var fieldOrder = map[string]int{"file": -4, "source": -3, "template": -2, "phase": -1, "duration": 1}
+
+func fieldKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if fieldOrder[keys[i]] != fieldOrder[keys[j]] {
+			return fieldOrder[keys[i]] < fieldOrder[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func fieldValue(value interface{}) interface{} {
+	// Durations and errors print better as strings.
+	switch v := value.(type) {
+	case time.Duration:
+		return v.Round(time.Microsecond).String()
+	case error:
+		return v.Error()
+	}
+	return value
+}
@@ -0,0 +1,332 @@
+package gen
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Every build records the files it generated in a manifest at the root of
+// the output, along with their source and the templates used. The next build
+// removes the files of the previous manifest that it did not generate again,
+// that is, whose source is gone, and `webgen clean` removes all of them.
+//
+// Only files listed in a manifest are ever removed, and only if they have not
+// been modified since they were generated: hand-written files are left alone.
+// The manifest is only kept for outputs that can be read back.
+//
+// As the manifest lists the sources of the site, it can be kept outside of
+// the output with SetManifest, so that it is not published with the site.
+
+type Manifest struct {
+	// Folders are generated as a whole (the output folders of collections).
+	Folders []ManifestEntry `json:"folders"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	File      string   `json:"file"`
+	Source    string   `json:"source"`
+	Templates []string `json:"templates,omitempty"`
+	// Hash of the content of the file, to detect changes made by hand.
+	Hash string `json:"sha256,omitempty"`
+}
+
+func newManifest() Manifest {
+	return Manifest{make([]ManifestEntry, 0), make([]ManifestEntry, 0)}
+}
+
+type manifestFile struct {
+	out  ReadableOutput
+	name string
+	// Set when the manifest was read from the root of the output rather
+	// than from where it is kept, and must be removed from there.
+	legacy bool
+}
+
+// SetManifest keeps the manifest in file name of out rather than at the root
+// of the output.
+
+func (b *Build) SetManifest(out ReadableOutput, name string) {
+	b.manifest = &manifestFile{out, name, false}
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadManifest reads the manifest of the last build from file name of out,
+// and reports whether there is one. A missing manifest gives an empty manifest.
+
+func ReadManifest(out ReadableOutput, name string) (Manifest, bool, error) {
+	data, err := out.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newManifest(), false, nil
+	}
+	if err != nil {
+		return newManifest(), false, err
+	}
+	manifest, err := parseManifest(data)
+	if err != nil {
+		return newManifest(), false, err
+	}
+	return manifest, true, nil
+}
+
+func parseManifest(data []byte) (Manifest, error) {
+	manifest := newManifest()
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return newManifest(), err
+	}
+	return manifest, nil
+}
+
+func (b *Build) readManifest() (Manifest, bool, bool) {
+	// The manifest of the previous build, whether there is one, and whether
+	// the output can tell.
+	if b.manifest == nil {
+		return newManifest(), false, false
+	}
+	manifest, found, err := ReadManifest(b.manifest.out, b.manifest.name)
+	if out, ok := b.out.Output.(ReadableOutput); ok && err == nil && !found && (out != b.manifest.out || b.manifest.name != MANIFEST) {
+		// Manifests used to be kept at the root of the output.
+		manifest, found, err = ReadManifest(out, MANIFEST)
+		b.manifest.legacy = found
+	}
+	if err != nil {
+		// Without a manifest, nothing is removed.
+		b.log.Log(LevelError, "cannot read manifest: "+err.Error(), Fields{"file": b.manifest.name})
+		return newManifest(), false, false
+	}
+	return manifest, found, true
+}
+
+func (b *Build) writeManifest(manifest Manifest) {
+	sort.Slice(manifest.Folders, func(i, j int) bool { return manifest.Folders[i].File < manifest.Folders[j].File })
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].File < manifest.Files[j].File })
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = writeOutput(b.manifest.out, b.manifest.name, append(data, '\n'))
+	}
+	if err != nil {
+		b.reportError(b.manifest.name, PhaseClean, err)
+		return
+	}
+	b.removeLegacyManifest()
+}
+
+func (b *Build) removeLegacyManifest() {
+	if !b.manifest.legacy {
+		return
+	}
+	if err := b.out.Output.RemoveAll(MANIFEST); err != nil {
+		b.reportError(MANIFEST, PhaseClean, err)
+		return
+	}
+	b.manifest.legacy = false
+}
+
+func (b *Build) excludeStale(prev Manifest) {
+	// When building in place, generated files are also sources (e.g., a
+	// .content file generated from a .md file). Those whose source is gone
+	// are left out of the build, so that nothing is generated from them.
+	if !b.inPlace {
+		return
+	}
+	stale := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, e := range prev.Files {
+			if stale[e.File] {
+				continue
+			}
+			if _, err := fs.Stat(b.fsys, e.Source); stale[e.Source] || errors.Is(err, fs.ErrNotExist) {
+				stale[e.File] = true
+				changed = true
+			}
+		}
+	}
+	for _, e := range prev.Files {
+		if data, err := fs.ReadFile(b.fsys, e.File); stale[e.File] && err == nil && hashOf(data) == e.Hash {
+			b.log.Log(LevelVerbose, "source is gone", Fields{"file": e.File, "source": e.Source})
+			b.Exclude(e.File)
+			b.site.removePage(e.File)
+		}
+	}
+}
+
+func (b *Build) updateManifest(ctx context.Context, root string, prev Manifest) {
+	// Files of the previous manifest under root that were not generated again
+	// are removed, unless the build failed: a failed build may have skipped
+	// files whose source is still there.
+	manifest := b.result.Manifest
+	generated := make(map[string]bool)
+	for _, e := range manifest.Files {
+		generated[e.File] = true
+	}
+	for _, e := range manifest.Folders {
+		generated[e.File] = true
+	}
+	clean := ctx.Err() == nil && !b.result.Failed()
+	removed := make([]ManifestEntry, 0)
+	for _, e := range prev.Folders {
+		if generated[e.File] {
+			continue
+		}
+		if clean && isUnder(e.File, root) {
+			removed = append(removed, e)
+			continue
+		}
+		manifest.Folders = append(manifest.Folders, e)
+	}
+	for _, e := range prev.Files {
+		if generated[e.File] {
+			continue
+		}
+		if clean && isUnder(e.File, root) {
+			b.removeFile(e)
+			continue
+		}
+		if !b.isModified(e) {
+			manifest.Files = append(manifest.Files, e)
+		}
+	}
+	// Folders go once their files are gone.
+	for _, e := range removed {
+		b.removeFolder(e)
+	}
+	b.writeManifest(manifest)
+}
+
+// Clean removes the files and folders listed in the manifest of the
+// previous build, along with the manifest.
+
+func (b *Build) Clean() *BuildResult {
+	prev, _, ok := b.readManifest()
+	if !ok {
+		return b.result
+	}
+	for _, e := range prev.Files {
+		b.removeFile(e)
+	}
+	for _, e := range prev.Folders {
+		b.removeFolder(e)
+	}
+	if err := b.manifest.out.RemoveAll(b.manifest.name); err != nil {
+		b.reportError(b.manifest.name, PhaseClean, err)
+	}
+	b.removeLegacyManifest()
+	b.log.Log(LevelInfo, "cleaned site", Fields{"files": len(b.result.Removed)})
+	return b.result
+}
+
+func (b *Build) isModified(e ManifestEntry) bool {
+	// Whether a generated file was changed (or removed) since it was generated.
+	out, ok := b.out.Output.(ReadableOutput)
+	if !ok {
+		return true
+	}
+	data, err := out.ReadFile(e.File)
+	return err != nil || hashOf(data) != e.Hash
+}
+
+func (b *Build) removeFile(e ManifestEntry) {
+	out, ok := b.out.Output.(ReadableOutput)
+	if !ok {
+		return
+	}
+	data, err := out.ReadFile(e.File)
+	if err != nil {
+		// Already gone.
+		return
+	}
+	if hashOf(data) != e.Hash {
+		b.log.Log(LevelInfo, "keeping modified file", Fields{"file": e.File, "source": e.Source})
+		return
+	}
+	if err := b.out.Output.RemoveAll(e.File); err != nil {
+		b.reportError(e.File, PhaseClean, err)
+		return
+	}
+	b.log.Log(LevelInfo, "removed", Fields{"file": e.File, "source": e.Source})
+	b.result.addRemoved(e.File)
+}
+
+func (b *Build) removeFolder(e ManifestEntry) {
+	// The files of a folder are removed one by one like the other files of
+	// the manifest. What is left are the folders that are now empty, which
+	// are removed, and the files that were added or modified by hand.
+	out, ok := b.out.Output.(ReadableOutput)
+	if !ok {
+		return
+	}
+	if _, err := out.Stat(e.File); err != nil {
+		// Already gone.
+		return
+	}
+	empty, err := removeEmptyDirs(out, e.File)
+	if err != nil {
+		b.reportError(e.File, PhaseClean, err)
+		return
+	}
+	if !empty {
+		b.log.Log(LevelInfo, "keeping folder with files not generated by webgen", Fields{"file": e.File, "source": e.Source})
+		return
+	}
+	b.log.Log(LevelInfo, "removed", Fields{"file": e.File, "source": e.Source})
+	b.result.addRemoved(e.File)
+}
+
+func removeEmptyDirs(out ReadableOutput, dir string) (bool, error) {
+	// Remove the empty folders under dir, and dir itself if it ends up empty.
+	entries, err := out.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	empty := true
+	for _, d := range entries {
+		if !d.IsDir() {
+			empty = false
+			continue
+		}
+		removed, err := removeEmptyDirs(out, path.Join(dir, d.Name()))
+		if err != nil {
+			return false, err
+		}
+		empty = empty && removed
+	}
+	if !empty {
+		return false, nil
+	}
+	return true, out.RemoveAll(dir)
+}
+
+func (b *Build) templatesOf(src string, phase string) []string {
+	// The template files used to generate a file from src, for the manifest.
+	source, err := b.source(src)
+	if err != nil {
+		return nil
+	}
+	layout := source.metadata.Layout()
+	names := make([]string, 0)
+	switch phase {
+	case PhaseMarkdown:
+		if _, tname, err := b.FindMarkdownTemplate(src, layout); err == nil && tname != "" {
+			names = append(names, tname)
+			names = append(names, b.res.extends(tname)...)
+		}
+	case PhaseContent:
+		templates, _ := b.findTemplate(src, layout)
+		for _, tinfo := range templates {
+			names = append(names, tinfo.name)
+			names = append(names, b.res.extends(tinfo.name)...)
+		}
+	}
+	return names
+}
@@ -1,13 +1,12 @@
 package gen
 
 import (
+	"bytes"
 	"github.com/russross/blackfriday/v2"
 	"html/template"
 	"io"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
 	"time"
 )
@@ -16,23 +15,33 @@ type Metadata struct {
 	Title   string
 	Date    time.Time
 	Reading string
+	// All front matter fields, by name.
+	Params map[string]string
 }
 
-func ProcessFileMarkdown(w io.Writer, fname string) error {
-	rep.Printf("%s\n", fname)
-	md, err := ioutil.ReadFile(fname)
+func (b *Build) ProcessFileMarkdown(w io.Writer, fname string) error {
+	b.log.Log(LevelVerbose, "processing", Fields{"file": fname, "phase": PhaseMarkdown})
+	source, err := b.source(fname)
 	if err != nil {
 		return err
 	}
-	metadata, restmd, err := ExtractMetadata(md)
+	metadata, restmd := source.metadata, source.body
+	restmd, err = b.ExpandShortcodes(fname, restmd)
 	if err != nil {
 		return err
 	}
 	output := blackfriday.Run(restmd, blackfriday.WithNoExtensions())
-	tpl, tname, err := FindMarkdownTemplate(fname)
+	tpl, tname, err := b.FindMarkdownTemplate(fname, metadata.Layout())
+	if err != nil {
+		return err
+	}
+	data, err := b.LoadData(fname)
+	if err != nil {
+		return err
+	}
 	if tpl != nil {
-		rep.Printf("  using markdown template %s\n", tname)
-		result, err := ProcessMarkdownTemplate(tpl, metadata, template.HTML(output))
+		b.log.Log(LevelVerbose, "using markdown template", Fields{"file": fname, "template": tname})
+		result, err := ProcessMarkdownTemplate(tpl, metadata, template.HTML(output), b.site, data, b.page(fname))
 		if err != nil {
 			return err
 		}
@@ -44,6 +53,19 @@ func ProcessFileMarkdown(w io.Writer, fname string) error {
 	return nil
 }
 
+func (b *Build) processFileMarkdownContent(w io.Writer, fname string) error {
+	// The .content file generated from a .md file keeps the front matter,
+	// so that it is passed on to the content templates.
+	source, err := b.source(fname)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(source.src[:len(source.src)-len(source.body)]); err != nil {
+		return err
+	}
+	return b.ProcessFileMarkdown(w, fname)
+}
+
 // This, or at least the STYLE, should really be a parameter to webgen.
 // Maybe a .css file in the .config/webgen folder?
 // More generally, the constants should move to a config file (JSON, Yaml, TOML, etc)
@@ -91,41 +113,68 @@ const draftTemplate = `
 </html>
 `
 
-func ProcessFileMarkdownDraft(fname string) error {
-	rep.Printf("%s\n", fname)
-	f, err := os.CreateTemp("", "draft*.html")
-	if err != nil {
-		return err
+// RenderDraft renders a markdown file for previewing it, with the built-in
+// draft template or, if site is true, with the markdown and content templates
+// of the site as if the file were a page of the site. A collection item is
+// rendered where the build generates it, with the layout of its collection.
+
+func (b *Build) RenderDraft(fname string, site bool) ([]byte, error) {
+	b.log.Log(LevelVerbose, "processing", Fields{"file": fname})
+	if site {
+		if page := b.site.PageBySource(fname); page != nil && page.Collection != "" {
+			layout := ""
+			for _, coll := range b.site.collections {
+				if coll.Name == page.Collection {
+					layout = coll.Layout
+				}
+			}
+			md, err := b.itemMarkdown(fname, layout)
+			if err != nil {
+				return nil, err
+			}
+			// As generated by ProcessFilesCollection.
+			fname = path.Join(path.Dir(strings.TrimPrefix(page.URL, "/")), "."+GENDIR, "index.md")
+			b.fsys.add(fname, md)
+		}
+		var buf bytes.Buffer
+		if err := b.processFileMarkdownContent(&buf, fname); err != nil {
+			return nil, err
+		}
+		target := path.Join(path.Dir(fname), targetFilename(path.Base(fname), "md", "content"))
+		b.fsys.add(target, buf.Bytes())
+		buf.Reset()
+		if err := b.ProcessFileContent(&buf, target); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
 	}
-	md, err := ioutil.ReadFile(fname)
+	source, err := b.source(fname)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, restmd, err := ExtractMetadata(md)
+	restmd, err := b.ExpandShortcodes(fname, source.body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	body := blackfriday.Run(restmd, blackfriday.WithNoExtensions())
 	mdtpl, err := template.New("draft").Parse(draftTemplate)
+	if err != nil {
+		return nil, err
+	}
 	var sb strings.Builder
 	if err := mdtpl.Execute(&sb, template.HTML(body)); err != nil {
-		return err
+		return nil, err
 	}
-	output := []byte(template.HTML(sb.String()))
-	//rep.Println(sb.String())
-	if _, err := f.Write(output); err != nil {
-		return err
-	}
-	rep.Printf("Draft file: %s\n", f.Name())
-	cmd := exec.Command("open", "-a", "Firefox", f.Name())
-	cmd.Run()
-	return nil
+	return []byte(sb.String()), nil
 }
 
 func ExtractMetadata(md []byte) (Metadata, []byte, error) {
+	// Front matter is a block of `field: value` lines between two `---` lines,
+	// at the very beginning of the file (up to blank lines).
 	title := ""
 	date := time.Time{}
 	reading := ""
+	params := make(map[string]string)
 	lines := strings.Split(string(md), "\n")
 	foundMetadata := false
 	for idx, line := range lines {
@@ -135,14 +184,15 @@ func ExtractMetadata(md []byte) (Metadata, []byte, error) {
 				if foundMetadata {
 					// We're done.
 					rest := []byte(strings.Join(lines[idx+1:], "\n"))
-					return Metadata{title, date, reading}, rest, nil
+					return Metadata{title, date, reading, params}, rest, nil
 				}
 				foundMetadata = true
 			} else if foundMetadata {
-				fields := strings.Split(line, ":")
+				fields := strings.SplitN(line, ":", 2)
 				if len(fields) == 2 {
 					fieldname := strings.TrimSpace(fields[0])
 					fieldvalue := strings.TrimSpace(fields[1])
+					params[fieldname] = fieldvalue
 					switch fieldname {
 					case "title":
 						title = fieldvalue
@@ -157,15 +207,31 @@ func ExtractMetadata(md []byte) (Metadata, []byte, error) {
 						}
 					}
 				}
+			} else {
+				// No front matter.
+				break
 			}
 		}
 	}
-	if foundMetadata {
-		return Metadata{}, md, nil
-	}
 	return Metadata{}, md, nil
 }
 
+func (m Metadata) Layout() string {
+	// Either `layout:` or `template:` in the front matter.
+	if layout := m.Params["layout"]; layout != "" {
+		return layout
+	}
+	return m.Params["template"]
+}
+
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
 func FormatDate(date time.Time) string {
 	if date.IsZero() {
 		return "-"
@@ -174,8 +240,12 @@ func FormatDate(date time.Time) string {
 	}
 }
 
-func ProcessMarkdownTemplate(tpl *template.Template, metadata Metadata, content template.HTML) (template.HTML, error) {
-	c := Content{metadata.Title, metadata.Date, FormatDate(metadata.Date), metadata.Reading, "", content}
+func layoutMarkdownTemplate(layout string) string {
+	return strings.TrimSuffix(MDTEMPLATE, ".template") + "." + layout + ".template"
+}
+
+func ProcessMarkdownTemplate(tpl *template.Template, metadata Metadata, content template.HTML, site *Site, data map[string]interface{}, page *PageInfo) (template.HTML, error) {
+	c := Content{metadata.Title, metadata.Date, FormatDate(metadata.Date), metadata.Reading, "", metadata.Params, content, site, data, page}
 	var b strings.Builder
 	if err := tpl.Execute(&b, c); err != nil {
 		return template.HTML(""), err
@@ -184,55 +254,64 @@ func ProcessMarkdownTemplate(tpl *template.Template, metadata Metadata, content
 	return result, nil
 }
 
-func FindMarkdownTemplate(path string) (*template.Template, string, error) {
-	// Given a path, find the nearest enclosing _gentemplate_md file.
-	previous, _ := filepath.Abs(path)
-	current := filepath.Dir(previous)
-	for current != previous {
-		gdPath, err := identifyGenDirPath(current)
-		if err == nil {
-			mdtname := filepath.Join(gdPath, MDTEMPLATE)
-			mdtpl, err := template.ParseFiles(mdtname)
-			if err == nil {
-				return mdtpl, mdtname, nil
+func (b *Build) FindMarkdownTemplate(fname string, layout string) (*template.Template, string, error) {
+	// If a layout is given, look for the nearest MARKDOWN.<layout>.template
+	// in place of MARKDOWN.template, falling back to MARKDOWN.template if there is none.
+	if layout != "" && isTemplateName(layout) {
+		tpl, tname, err := b.findMarkdownTemplate(fname, layoutMarkdownTemplate(layout))
+		if tpl != nil || err != nil {
+			return tpl, tname, err
+		}
+		b.log.Log(LevelDebug, "no markdown template found for layout", Fields{"file": fname, "layout": layout})
+	}
+	return b.findMarkdownTemplate(fname, MDTEMPLATE)
+}
+
+func (b *Build) findMarkdownTemplate(fname string, name string) (*template.Template, string, error) {
+	// Given a path, find the nearest enclosing markdown template file.
+	for _, dir := range parentDirs(fname) {
+		if gdPath, ok := b.searchDir(dir); ok {
+			mdtpl, mdtname, err := b.findTemplateFile(gdPath, name)
+			if err != nil || mdtpl != nil {
+				return mdtpl, mdtname, err
 			}
 		}
-		previous = current
-		current = filepath.Dir(current)
 	}
 	return nil, "", nil
 }
 
-func ProcessFilesMarkdown(cwd string, path string) {
-	gdPath, err := identifyGenDirPath(path)
+func (b *Build) markdownTasks(dir string) []task {
+	// One task per .md file in the __src folder of dir.
+	gdPath, err := b.res.genDirPath(dir)
 	if err != nil {
-		return
+		return nil
 	}
-	entries, err := os.ReadDir(gdPath)
+	entries, err := fs.ReadDir(b.fsys, gdPath)
 	if err != nil {
 		// if we can't read GENDIR, skip.
-		return
+		return nil
 	}
+	tasks := make([]task, 0)
 	for _, d := range entries {
 		if !d.IsDir() && IsMarkdown(d.Name()) {
-			relPath, err := filepath.Rel(cwd, gdPath)
-			if err != nil {
-				relPath = gdPath
-			}
-			target := filepath.Join(relPath, targetFilename(d.Name(), "md", "content"))
-			w, err := os.Create(target)
-			if err != nil {
-				w.Close()
-				rep.Printf("ERROR: %s\n", err)
-				continue
-			}
-			if err := ProcessFileMarkdown(w, filepath.Join(relPath, d.Name())); err != nil {
-				w.Close()
-				rep.Printf("ERROR: %s\n", err)
-				continue
-			}
-			rep.Printf("  wrote %s", target)
-			w.Close()
+			src := path.Join(gdPath, d.Name())
+			target := path.Join(gdPath, targetFilename(d.Name(), "md", "content"))
+			tasks = append(tasks, func(t *Build) { t.generateMarkdown(src, target) })
 		}
 	}
+	return tasks
+}
+
+func (b *Build) generateMarkdown(src string, target string) {
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := b.processFileMarkdownContent(&buf, src); err != nil {
+		b.reportError(src, PhaseMarkdown, err)
+		return
+	}
+	if err := b.writeFile(target, src, PhaseMarkdown, buf.Bytes()); err != nil {
+		b.reportError(src, PhaseMarkdown, err)
+		return
+	}
+	b.reportWritten(target, src, b.templatesOf(src, PhaseMarkdown), PhaseMarkdown, start)
 }
@@ -0,0 +1,379 @@
+package gen
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output is where generated files are written.
+// Names are slash-separated paths relative to the root of the output.
+
+type Output interface {
+	Create(name string) (io.WriteCloser, error)
+	MkdirAll(name string) error
+	RemoveAll(name string) error
+}
+
+// An output can also build a folder aside and swap it in at once when done,
+// so that the folder is never left half-generated. Stage returns an output
+// for the new content of the folder. Commit replaces the folder with the
+// staged one, and Discard throws the staged one away.
+
+type StagingOutput interface {
+	Output
+	Stage(name string) (Output, error)
+	Commit(name string, staged Output) error
+	Discard(staged Output) error
+}
+
+// An output can also be read back, e.g., to find the files generated by a
+// previous build. Tasks of a build read the output while other files are
+// being written to it, so reading must be safe alongside writing.
+
+type ReadableOutput interface {
+	Output
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// Files and folders used by webgen while writing to the output start with
+// this prefix, and are skipped by builds.
+const tempPrefix = ".webgen-"
+
+// DirOutput writes to a folder on disk.
+// Files are written to a temporary file and renamed into place when closed,
+// so that they are never left truncated.
+
+type DirOutput struct {
+	Dir string
+}
+
+func NewDirOutput(dir string) *DirOutput {
+	return &DirOutput{dir}
+}
+
+func (o *DirOutput) Create(name string) (io.WriteCloser, error) {
+	target := filepath.Join(o.Dir, filepath.FromSlash(name))
+	f, err := os.CreateTemp(filepath.Dir(target), tempPrefix+filepath.Base(target)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{f, target, nil}, nil
+}
+
+type atomicFile struct {
+	f      *os.File
+	target string
+	// The first error while writing, if any.
+	err error
+}
+
+func (a *atomicFile) Write(b []byte) (int, error) {
+	n, err := a.f.Write(b)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return n, err
+}
+
+func (a *atomicFile) Close() error {
+	// Only rename into place if everything was written.
+	err := a.f.Close()
+	if a.err == nil && err == nil {
+		err = os.Chmod(a.f.Name(), 0644)
+	}
+	if a.err == nil && err == nil {
+		err = os.Rename(a.f.Name(), a.target)
+	}
+	if a.err != nil || err != nil {
+		os.Remove(a.f.Name())
+	}
+	if a.err != nil {
+		return a.err
+	}
+	return err
+}
+
+func (o *DirOutput) Stage(name string) (Output, error) {
+	target := filepath.Join(o.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(target), tempPrefix+"staging-"+filepath.Base(target)+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	return NewDirOutput(staging), nil
+}
+
+func (o *DirOutput) Commit(name string, staged Output) error {
+	// The previous folder is kept as a backup until the staged folder is in place.
+	target := filepath.Join(o.Dir, filepath.FromSlash(name))
+	staging := staged.(*DirOutput).Dir
+	backup := ""
+	if _, err := os.Stat(target); err == nil {
+		backup = filepath.Join(filepath.Dir(target), fmt.Sprintf("%sbackup-%s-%d", tempPrefix, filepath.Base(target), time.Now().UnixNano()))
+		if err := os.Rename(target, backup); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staging, target); err != nil {
+		if backup != "" {
+			os.Rename(backup, target)
+		}
+		return err
+	}
+	if backup != "" {
+		return os.RemoveAll(backup)
+	}
+	return nil
+}
+
+func (o *DirOutput) Discard(staged Output) error {
+	return os.RemoveAll(staged.(*DirOutput).Dir)
+}
+
+func (o *DirOutput) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(o.Dir, filepath.FromSlash(name)))
+}
+
+func (o *DirOutput) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(filepath.Join(o.Dir, filepath.FromSlash(name)))
+}
+
+func (o *DirOutput) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(filepath.Join(o.Dir, filepath.FromSlash(name)))
+}
+
+func (o *DirOutput) MkdirAll(name string) error {
+	return os.MkdirAll(filepath.Join(o.Dir, filepath.FromSlash(name)), 0755)
+}
+
+func (o *DirOutput) RemoveAll(name string) error {
+	return os.RemoveAll(filepath.Join(o.Dir, filepath.FromSlash(name)))
+}
+
+// MemOutput keeps generated files in memory. Files must not be accessed
+// directly while a build is writing to the output.
+
+type MemOutput struct {
+	mu    sync.RWMutex
+	Files map[string][]byte
+}
+
+func NewMemOutput() *MemOutput {
+	return &MemOutput{sync.RWMutex{}, make(map[string][]byte)}
+}
+
+func (o *MemOutput) Create(name string) (io.WriteCloser, error) {
+	return &bufferWriter{func(data []byte) error {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.Files[name] = data
+		return nil
+	}, bytes.Buffer{}}, nil
+}
+
+func (o *MemOutput) ReadFile(name string) ([]byte, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	data, ok := o.Files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (o *MemOutput) Stat(name string) (fs.FileInfo, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if data, ok := o.Files[name]; ok {
+		return memFileInfo{path.Base(name), int64(len(data)), false}, nil
+	}
+	for fname := range o.Files {
+		if isUnder(fname, name) {
+			return memFileInfo{path.Base(name), 0, true}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *MemOutput) ReadDir(name string) ([]fs.DirEntry, error) {
+	// Folders only exist as the folders of files.
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	entries := make(map[string]fs.DirEntry)
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	for fname, data := range o.Files {
+		if !strings.HasPrefix(fname, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(fname, prefix)
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			entries[rest[:idx]] = fs.FileInfoToDirEntry(memFileInfo{rest[:idx], 0, true})
+		} else {
+			entries[rest] = fs.FileInfoToDirEntry(memFileInfo{rest, int64(len(data)), false})
+		}
+	}
+	if len(entries) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, d := range entries {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+func (o *MemOutput) MkdirAll(name string) error {
+	return nil
+}
+
+func (o *MemOutput) RemoveAll(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for fname := range o.Files {
+		if isUnder(fname, name) {
+			delete(o.Files, fname)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the files in the output, in order.
+
+func (o *MemOutput) Names() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	names := make([]string, 0, len(o.Files))
+	for name := range o.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ZipOutput writes to a zip archive. Files cannot be removed from a zip
+// archive, so removals are ignored; a build only removes files before
+// writing them. Close must be called to complete the archive.
+
+type ZipOutput struct {
+	w *zip.Writer
+}
+
+func NewZipOutput(w io.Writer) *ZipOutput {
+	return &ZipOutput{zip.NewWriter(w)}
+}
+
+func (o *ZipOutput) Create(name string) (io.WriteCloser, error) {
+	w, err := o.w.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (o *ZipOutput) MkdirAll(name string) error {
+	return nil
+}
+
+func (o *ZipOutput) RemoveAll(name string) error {
+	return nil
+}
+
+func (o *ZipOutput) Close() error {
+	return o.w.Close()
+}
+
+// TarOutput writes to a tar stream. As for ZipOutput, removals are ignored.
+// Close must be called to complete the stream.
+
+type TarOutput struct {
+	w *tar.Writer
+	// Folders already written to the stream.
+	dirs map[string]bool
+}
+
+func NewTarOutput(w io.Writer) *TarOutput {
+	return &TarOutput{tar.NewWriter(w), make(map[string]bool)}
+}
+
+func (o *TarOutput) Create(name string) (io.WriteCloser, error) {
+	// The size of a file must be known before writing it.
+	return &bufferWriter{func(data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := o.w.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := o.w.Write(data)
+		return err
+	}, bytes.Buffer{}}, nil
+}
+
+func (o *TarOutput) MkdirAll(name string) error {
+	if name == "." || name == "" {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(name, "/") {
+		current = current + part + "/"
+		if o.dirs[current] {
+			continue
+		}
+		header := &tar.Header{Name: current, Mode: 0755, ModTime: time.Now(), Typeflag: tar.TypeDir}
+		if err := o.w.WriteHeader(header); err != nil {
+			return err
+		}
+		o.dirs[current] = true
+	}
+	return nil
+}
+
+func (o *TarOutput) RemoveAll(name string) error {
+	return nil
+}
+
+func (o *TarOutput) Close() error {
+	return o.w.Close()
+}
+
+type bufferWriter struct {
+	done func([]byte) error
+	buf  bytes.Buffer
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *bufferWriter) Close() error {
+	return w.done(w.buf.Bytes())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
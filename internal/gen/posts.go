@@ -2,12 +2,8 @@ package gen
 
 import (
 	"html/template"
-	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"sort"
-	"strconv"
+	"io/fs"
+	"path"
 	"strings"
 	"time"
 )
@@ -19,193 +15,196 @@ type PostInfo struct {
 	Date    time.Time
 	Reading string
 	// Key is of the form YYYY/entry-name and is the folder under `posts/` that contains the generated post.
+	// For other collections, it is obtained from the permalink pattern of the collection.
 	Key string
 	// Field `year` is not used but kept around in case it's needed.
-	Year int
+	Year   int
+	Params map[string]string
+	// Source is the folder of the item, relative to the collection folder.
+	Source string
 }
 
-func ExtractPosts(path string) ([]PostInfo, error) {
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-	posts := make([]PostInfo, 0)
-	for _, y := range entries {
-		// Only look at years.
-		if y.IsDir() {
-			year, err := strconv.Atoi(y.Name())
-			if err != nil {
-				continue
-			}
-			year = year
-			subEntries, err := os.ReadDir(filepath.Join(path, y.Name()))
-			if err != nil {
-				return nil, err
-			}
-			for _, d := range subEntries {
-				if d.IsDir() && d.Name() != GENDIR && d.Name() != ("."+GENDIR) {
-					md, err := ioutil.ReadFile(filepath.Join(path, y.Name(), d.Name(), POSTMD))
-					if err != nil {
-						return nil, err
-					}
-					metadata, _, err := ExtractMetadata(md)
-					if err != nil {
-						return nil, err
-					}
-					posts = append(posts, PostInfo{metadata.Title, metadata.Date, metadata.Reading, filepath.Join(y.Name(), d.Name()), year})
-				}
-			}
-		}
-	}
-	return posts, nil
+func ExtractPosts(fsys fs.FS, dir string) ([]PostInfo, error) {
+	return ExtractCollection(fsys, dir, defaultCollection(GENPOSTS, Collection{}))
 }
 
-type byDate []PostInfo
+// IsDraft tells whether an item has `draft: true` in its front matter.
 
-func (s byDate) Len() int {
-	return len(s)
+func (p PostInfo) IsDraft() bool {
+	return isTrue(p.Params["draft"])
 }
 
-func (s byDate) Swap(i int, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s byDate) Less(i int, j int) bool {
-	// Alphabetical order is fine for now for dates.
-	return s[i].Date.After(s[j].Date)
+func (b *Build) collectionTasks(dir string) []task {
+	// One task per collection in the __src folder of dir.
+	tasks := make([]task, 0)
+	for _, coll := range b.site.collections {
+		if _, err := identifyGenCollection(b.fsys, dir, coll.Name); err != nil {
+			continue
+		}
+		coll := coll
+		tasks = append(tasks, func(t *Build) { t.ProcessFilesCollection(dir, coll) })
+	}
+	return tasks
 }
 
-func ProcessFilesPosts(cwd string, path string) {
-	genPosts, err := identifyGenPosts(path)
+func (b *Build) ProcessFilesCollection(dir string, coll Collection) {
+	genColl, err := identifyGenCollection(b.fsys, dir, coll.Name)
 	if err != nil {
 		return
 	}
-	// Get full list of posts.
-	postPath := filepath.Join(path, genPosts)
-	rep.Printf("%s\n", postPath)
-	posts, err := ExtractPosts(postPath)
+	// Get full list of items.
+	collPath := path.Join(dir, genColl)
+	start := time.Now()
+	b.log.Log(LevelVerbose, "processing", Fields{"file": collPath, "phase": PhaseCollections})
+	posts, ok := b.site.items[collPath]
+	if !ok {
+		// Not loaded with the site.
+		posts, err = extractCollection(newSourceFiles(b.fsys), collPath, coll, b.site.drafts)
+	}
 	if err != nil {
-		rep.Printf("ERROR: %s\n", err)
+		b.reportError(collPath, PhaseCollections, err)
 		return
 	}
-	sort.Sort(byDate(posts))
-	///rep.Printf("Posts = %v\n", posts)
-	relPath, err := filepath.Rel(cwd, path)
-	if err != nil {
-		relPath = path
-	}
-	// Clear out /post folder completely.
-	postDir := filepath.Join(relPath, POSTDIR)
-	rep.Printf("  removing %s\n", postDir)
-	os.RemoveAll(postDir)
-	if err := os.Mkdir(postDir, 0755); err != nil {
-		rep.Printf("ERROR: %s\n", err)
+	// Regenerate the output folder from scratch.
+	postDir := path.Join(dir, coll.Output)
+	b.log.Log(LevelVerbose, "regenerating", Fields{"file": postDir})
+	if err := b.stageDir(postDir, collPath); err != nil {
+		b.reportError(collPath, PhaseCollections, err)
 		return
 	}
-	// Copy post folders.
+	// Copy item folders.
 	for _, p := range posts {
-		if err := os.MkdirAll(filepath.Join(postDir, p.Key), 0755); err != nil {
-			rep.Printf("ERROR: %s\n", err)
-			continue
-		}
-		// Copy content of folder p.Key.
+		// Copy content of folder p.Source.
 		// This does not go into subfolders!
-		rep.Printf("  copying %s\n", p.Key)
-		postEntries, err := os.ReadDir(filepath.Join(relPath, genPosts, p.Key))
+		b.log.Log(LevelVerbose, "copying", Fields{"file": path.Join(collPath, p.Source), "key": p.Key})
+		srcPath := path.Join(collPath, p.Source)
+		postEntries, err := fs.ReadDir(b.fsys, srcPath)
 		if err != nil {
-			rep.Printf("ERROR: %s\n", err)
+			b.reportError(srcPath, PhaseCollections, err)
 			continue
 		}
 		for _, f := range postEntries {
 			if !f.IsDir() {
-				srcPath := filepath.Join(relPath, genPosts, p.Key)
 				srcName := f.Name()
-				dstPath := filepath.Join(postDir, p.Key)
+				dstPath := path.Join(postDir, p.Key)
 				dstName := f.Name()
 				if f.Name() == POSTMD {
-					// Eventually, want to do something smart, like insert
-					//  previous/next keys in the metadata to be able to handle
-					//  navigation at the level of posts.
-					// We can only do that if we have the full list of posts
-					//  though, so we'll need to restructure to read all
-					//  posts, sort them by date, THEN process them.
-					// genDir, err := identifyGenDir(dstPath)
-					// if err != nil {
-					// 	rep.Printf("ERROR: %s\n", err)
-					// 	continue
-					// }
-					dstPath = filepath.Join(dstPath, "."+GENDIR)
+					// Navigation between items is available from
+					//  .Page.Prev and .Page.Next in templates.
+					dstPath = path.Join(dstPath, "."+GENDIR)
 					dstName = "index.md"
-					if err := os.Mkdir(dstPath, 0755); err != nil {
-						rep.Printf("ERROR: %s\n", err)
+					if err := b.copyItemMarkdown(path.Join(srcPath, srcName), path.Join(dstPath, dstName), coll.Layout); err != nil {
+						b.reportError(path.Join(srcPath, srcName), PhaseCollections, err)
 						continue
 					}
-				}
-				fsrc, err := os.Open(filepath.Join(srcPath, srcName))
-				if err != nil {
-					rep.Printf("ERROR: %s\n", err)
-					continue
-				}
-				fdst, err := os.Create(filepath.Join(dstPath, dstName))
-				if err != nil {
-					rep.Printf("ERROR: %s\n", err)
-					fsrc.Close()
+					b.addWritten(path.Join(dstPath, dstName), path.Join(srcPath, srcName), nil)
 					continue
 				}
-				if _, err := io.Copy(fdst, fsrc); err != nil {
-					rep.Printf("ERROR: %s\n", err)
-					fsrc.Close()
-					fdst.Close()
+				if err := b.copyFile(path.Join(srcPath, srcName), path.Join(dstPath, dstName)); err != nil {
+					b.reportError(path.Join(srcPath, srcName), PhaseCollections, err)
 					continue
 				}
+				b.addWritten(path.Join(dstPath, dstName), path.Join(srcPath, srcName), nil)
 			}
 		}
 	}
+	b.writeRedirects(collPath, postDir, coll, posts)
 	// Extract list of summaries.
-	genDir, err := identifyGenDir(relPath)
-	if err != nil {
-		rep.Printf("ERROR: %s\n", err)
-		return
-	}
-	target := filepath.Join(relPath, genDir, "index.content")
-	w, err := os.Create(target)
-	defer w.Close()
+	genDir, err := b.res.genDir(dir)
 	if err != nil {
-		rep.Printf("ERROR: %s\n", err)
+		b.reportError(collPath, PhaseCollections, err)
 		return
 	}
+	target := path.Join(dir, genDir, coll.Index)
 	postsContent := make([]Content, 0, len(posts))
 	for _, p := range posts {
-		src := filepath.Join(relPath, genPosts, p.Key, POSTMD)
-		metadata, err := ProcessFilePost(p.Key, src)
-		if err != nil {
-			rep.Printf("ERROR: %s\n", err)
-			continue
-		}
-		content := Content{metadata.Title, metadata.Date, FormatDate(metadata.Date), metadata.Reading, p.Key, template.HTML("")}
+		page := b.site.Page(siteURL(path.Join(postDir, p.Key, "index.html")))
+		content := Content{p.Title, p.Date, FormatDate(p.Date), p.Reading, p.Key, p.Params, template.HTML(""), b.site, nil, page}
 		postsContent = append(postsContent, content)
 	}
-	tpl, tname, err := FindSummaryTemplate(postPath)
+	tpl, tname, err := b.FindSummaryTemplate(collPath, coll.Summary)
+	if err != nil {
+		b.reportError(collPath, PhaseCollections, err)
+		return
+	}
+	data, err := b.LoadData(collPath)
+	if err != nil {
+		b.reportError(collPath, PhaseCollections, err)
+		return
+	}
 	output := []byte("")
 	if tpl != nil {
-		rep.Printf("  using summary template %s\n", tname)
-		content := SummaryContent{postsContent}
-		result, err := ProcessSummaryTemplate(tpl, content)
+		b.log.Log(LevelVerbose, "using summary template", Fields{"file": collPath, "template": tname})
+		content := SummaryContent{coll.Name, postsContent, b.site, data}
+		summary, err := ProcessSummaryTemplate(tpl, content)
 		if err != nil {
-			rep.Printf("ERROR: %s\n", err)
+			b.reportError(collPath, PhaseCollections, err)
 			return
 		}
-		output = []byte(result)
+		output = []byte(summary)
 	}
-	if _, err := w.Write(output); err != nil {
-		rep.Printf("ERROR: %s\n", err)
+	if err := b.writeFile(target, collPath, PhaseCollections, output); err != nil {
+		b.reportError(collPath, PhaseCollections, err)
 		return
 	}
-	rep.Printf("  wrote %s", target)
+	templates := make([]string, 0)
+	if tname != "" {
+		templates = append(templates, tname)
+		templates = append(templates, b.res.extends(tname)...)
+	}
+	b.reportWritten(target, collPath, templates, PhaseCollections, start)
+}
+
+func (b *Build) copyFile(src string, dst string) error {
+	data, err := fs.ReadFile(b.fsys, src)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(dst, src, PhaseCollections, data)
+}
+
+func (b *Build) copyItemMarkdown(src string, dst string, layout string) error {
+	md, err := b.itemMarkdown(src, layout)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(dst, src, PhaseCollections, md)
+}
+
+func (b *Build) itemMarkdown(src string, layout string) ([]byte, error) {
+	// The markdown of an item, with the default layout of its collection
+	// if it does not specify one.
+	source, err := b.source(src)
+	if err != nil {
+		return nil, err
+	}
+	md, rest := source.src, source.body
+	if layout != "" {
+		if source.metadata.Layout() == "" {
+			frontMatter := string(md[:len(md)-len(rest)])
+			if frontMatter == "" {
+				frontMatter = "---\n---\n"
+			}
+			// Add the layout right after the opening --- line, with the same line ending.
+			start := strings.Index(frontMatter, "---")
+			idx := start + strings.IndexByte(frontMatter[start:], '\n') + 1
+			eol := "\n"
+			if strings.HasSuffix(frontMatter[:idx], "\r\n") {
+				eol = "\r\n"
+			}
+			frontMatter = frontMatter[:idx] + "layout: " + layout + eol + frontMatter[idx:]
+			md = append([]byte(frontMatter), rest...)
+		}
+	}
+	return md, nil
 }
 
 type SummaryContent struct {
-	Posts []Content
+	// Name of the collection.
+	Collection string
+	Posts      []Content
+	Site       *Site
+	Data       map[string]interface{}
 }
 
 func ProcessSummaryTemplate(tpl *template.Template, content SummaryContent) (template.HTML, error) {
@@ -217,32 +216,15 @@ func ProcessSummaryTemplate(tpl *template.Template, content SummaryContent) (tem
 	return result, nil
 }
 
-func ProcessFilePost(key string, fname string) (Metadata, error) {
-	rep.Printf("%s\n", fname)
-	md, err := ioutil.ReadFile(fname)
-	if err != nil {
-		return Metadata{}, err
-	}
-	metadata, _, err := ExtractMetadata(md)
-	return metadata, err
-}
-
-func FindSummaryTemplate(path string) (*template.Template, string, error) {
-	// Given a path, find the nearest enclosing SUMMARY.template file.
-	previous, _ := filepath.Abs(path)
-	current := filepath.Dir(previous)
-	for current != previous {
-		///rep.Printf("[trying %s]\n", current)
-		gdPath, err := identifyGenDirPath(current)
-		if err == nil {
-			mdtname := filepath.Join(gdPath, SUMMARYTEMPLATE)
-			mdtpl, err := template.ParseFiles(mdtname)
-			if err == nil {
-				return mdtpl, mdtname, nil
+func (b *Build) FindSummaryTemplate(dir string, name string) (*template.Template, string, error) {
+	// Given a path, find the nearest enclosing summary template file.
+	for _, current := range parentDirs(dir) {
+		if gdPath, ok := b.searchDir(current); ok {
+			mdtpl, mdtname, err := b.findTemplateFile(gdPath, name)
+			if err != nil || mdtpl != nil {
+				return mdtpl, mdtname, err
 			}
 		}
-		previous = current
-		current = filepath.Dir(current)
 	}
 	return nil, "", nil
 }
@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCopyItemMarkdownLayout(t *testing.T) {
+	tests := []struct {
+		md   string
+		want string
+	}{
+		{"---\ntitle: A\n---\nBody\n", "---\nlayout: talk\ntitle: A\n---\nBody\n"},
+		{"---\r\ntitle: A\r\n---\r\nBody\r\n", "---\r\nlayout: talk\r\ntitle: A\r\n---\r\nBody\r\n"},
+		{"\n---\ntitle: A\n---\n", "\n---\nlayout: talk\ntitle: A\n---\n"},
+		{"Body\n", "---\nlayout: talk\n---\nBody\n"},
+		{"---\nlayout: other\n---\n", "---\nlayout: other\n---\n"},
+	}
+	for _, test := range tests {
+		b := newTestBuild(t, fstest.MapFS{"__src/TALKS/a/index.md": {Data: []byte(test.md)}})
+		if err := b.copyItemMarkdown("__src/TALKS/a/index.md", "talks/a/.__src/index.md", "talk"); err != nil {
+			t.Fatal(err)
+		}
+		result, err := b.fsys.ReadFile("talks/a/.__src/index.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result) != test.want {
+			t.Errorf("copyItemMarkdown(%q) = %q, want %q", test.md, result, test.want)
+		}
+	}
+}
+
+func TestItemKey(t *testing.T) {
+	item := PostInfo{Title: "Hello World", Date: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), Source: "2023/old"}
+	tests := []struct {
+		permalink string
+		source    string
+		want      string
+	}{
+		{":key", "2024/new", "2024/new"},
+		{":slug", "2024/new", "new"},
+		// The year comes from the year folder, and otherwise from the date.
+		{":year/:slug", "2024/new", "2024/new"},
+		{":year/:slug", "new", "2023/new"},
+		{":title", "2024/new", "hello-world"},
+	}
+	for _, test := range tests {
+		if got := ItemKey(Collection{Permalink: test.permalink}, item, test.source); got != test.want {
+			t.Errorf("ItemKey(%q, %q) = %q, want %q", test.permalink, test.source, got, test.want)
+		}
+	}
+}
+
+func TestRenderDraftItem(t *testing.T) {
+	// An item is rendered with the layout of its collection, from the folder of its key.
+	fsys := fstest.MapFS{
+		"__src/" + TEMPLATE:                       {Data: []byte("<html>{{.Body}}</html>\n")},
+		"__src/" + layoutMarkdownTemplate("post"): {Data: []byte("<h1>{{.Title}}</h1>{{.Page.URL}}")},
+		"__src/POSTS/2023/old/index.md":           {Data: []byte("---\ntitle: Old\ndraft: true\n---\nHello\n")},
+	}
+	site, err := LoadSite(fsys, Config{Collections: map[string]Collection{GENPOSTS: {Permalink: ":slug", Layout: "post"}}, Drafts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuild(fsys, NewMemOutput(), site, false, NewTextLogger(io.Discard, LevelError))
+	result, err := b.RenderDraft("__src/POSTS/2023/old/index.md", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<html><h1>Old</h1>/posts/old/index.html</html>\n"
+	if string(result) != want {
+		t.Errorf("RenderDraft = %q, want %q", result, want)
+	}
+}
@@ -0,0 +1,104 @@
+package gen
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// An item that moved lists its former keys in its front matter:
+//
+//   aliases: 2023/old-name, 2024/other-name
+//
+// and a redirect page is generated at each of them in the output folder of
+// the collection. Setting `redirects = "htaccess"` for the collection also
+// writes the redirects to an .htaccess file in the output folder, for Apache.
+
+const redirectTemplate = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Redirecting to {{.}}</title>
+    <link rel="canonical" href="{{.}}">
+    <meta http-equiv="refresh" content="0; url={{.}}">
+  </head>
+  <body>
+    <p>This page has moved to <a href="{{.}}">{{.}}</a>.</p>
+  </body>
+</html>
+`
+
+const HTACCESS = ".htaccess"
+
+// Aliases returns the former keys of an item, from `aliases:` in its front matter.
+
+func (p PostInfo) Aliases() []string {
+	result := make([]string, 0)
+	for _, alias := range strings.Split(p.Params["aliases"], ",") {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			result = append(result, alias)
+		}
+	}
+	return result
+}
+
+func (b *Build) redirectURL(target string) string {
+	// The URL to redirect to, absolute if the site has a base URL.
+	url := siteURL(target) + "/"
+	if b.site.BaseURL != "" {
+		return strings.TrimSuffix(b.site.BaseURL, "/") + url
+	}
+	return url
+}
+
+func (b *Build) writeRedirects(collPath string, postDir string, coll Collection, posts []PostInfo) {
+	keys := make(map[string]bool)
+	for _, p := range posts {
+		keys[p.Key] = true
+	}
+	tpl := template.Must(template.New("redirect").Parse(redirectTemplate))
+	var htaccess strings.Builder
+	for _, p := range posts {
+		src := path.Join(collPath, p.Source, POSTMD)
+		for _, alias := range p.Aliases() {
+			alias = path.Clean(alias)
+			if !fs.ValidPath(alias) || alias == "." {
+				b.reportError(src, PhaseCollections, fmt.Errorf("invalid alias %s", alias))
+				continue
+			}
+			if keys[alias] {
+				b.reportError(src, PhaseCollections, fmt.Errorf("alias %s is the key of an item", alias))
+				continue
+			}
+			keys[alias] = true
+			url := b.redirectURL(path.Join(postDir, p.Key))
+			var sb strings.Builder
+			if err := tpl.Execute(&sb, url); err != nil {
+				b.reportError(src, PhaseCollections, err)
+				continue
+			}
+			target := path.Join(postDir, alias, "index.html")
+			if err := b.writeFile(target, src, PhaseCollections, []byte(sb.String())); err != nil {
+				b.reportError(src, PhaseCollections, err)
+				continue
+			}
+			b.log.Log(LevelVerbose, "redirecting", Fields{"file": target, "key": p.Key})
+			b.addWritten(target, src, nil)
+			fmt.Fprintf(&htaccess, "Redirect 301 %s/ %s\n", siteURL(path.Join(postDir, alias)), url)
+		}
+	}
+	switch coll.Redirects {
+	case "":
+	case "htaccess":
+		target := path.Join(postDir, HTACCESS)
+		if err := b.writeFile(target, collPath, PhaseCollections, []byte(htaccess.String())); err != nil {
+			b.reportError(collPath, PhaseCollections, err)
+			return
+		}
+		b.addWritten(target, collPath, nil)
+	default:
+		b.reportError(collPath, PhaseCollections, fmt.Errorf("unknown redirects %q", coll.Redirects))
+	}
+}
@@ -0,0 +1,113 @@
+package gen
+
+import (
+	"html/template"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// The resolver of a build caches the lookups of __src folders and the parsed
+// template files, so that each template file is read and parsed once per
+// build. Every use of a template gets its own clone of the parsed template.
+//
+// Files generated by a phase can add __src folders (e.g., in the items of
+// collections), so __src folder lookups are forgotten at the start of every
+// phase. Template files are never generated, so they are kept for the
+// whole build.
+
+type resolver struct {
+	fsys      fs.FS
+	mu        sync.Mutex
+	genDirs   map[string]genDirLookup
+	templates map[string]parsedTemplate
+}
+
+type genDirLookup struct {
+	gdPath string
+	err    error
+}
+
+type parsedTemplate struct {
+	// Nil if the template file does not exist.
+	template *template.Template
+	// The templates extended by the template file, nearest first.
+	extends []string
+	err     error
+}
+
+func newResolver(fsys fs.FS) *resolver {
+	return &resolver{fsys, sync.Mutex{}, make(map[string]genDirLookup), make(map[string]parsedTemplate)}
+}
+
+func (r *resolver) resetGenDirs() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.genDirs = make(map[string]genDirLookup)
+}
+
+func (r *resolver) genDirPath(dir string) (string, error) {
+	r.mu.Lock()
+	lookup, ok := r.genDirs[dir]
+	r.mu.Unlock()
+	if ok {
+		return lookup.gdPath, lookup.err
+	}
+	gdPath, err := identifyGenDirPath(r.fsys, dir)
+	r.mu.Lock()
+	r.genDirs[dir] = genDirLookup{gdPath, err}
+	r.mu.Unlock()
+	return gdPath, err
+}
+
+func (r *resolver) genDir(dir string) (string, error) {
+	gdPath, err := r.genDirPath(dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(gdPath), nil
+}
+
+func (r *resolver) parsedTemplate(tname string) parsedTemplate {
+	// Two tasks may parse the same template file at the same time;
+	// they get the same result, so either one can be kept.
+	r.mu.Lock()
+	parsed, ok := r.templates[tname]
+	r.mu.Unlock()
+	if ok {
+		return parsed
+	}
+	if _, err := fs.Stat(r.fsys, tname); err != nil {
+		parsed = parsedTemplate{nil, nil, nil}
+	} else {
+		tpl, extends, err := r.parseTemplateFile(tname)
+		parsed = parsedTemplate{tpl, extends, err}
+	}
+	r.mu.Lock()
+	r.templates[tname] = parsed
+	r.mu.Unlock()
+	return parsed
+}
+
+func (r *resolver) findTemplateFile(gdPath string, name string) (*template.Template, string, error) {
+	// Parse template file name in gdPath if it exists.
+	// Returns a nil template if it doesn't.
+	tname := path.Join(gdPath, name)
+	parsed := r.parsedTemplate(tname)
+	if parsed.err != nil {
+		return nil, "", parsed.err
+	}
+	if parsed.template == nil {
+		return nil, "", nil
+	}
+	// The parsed template is never executed, so it can always be cloned.
+	tpl, err := parsed.template.Clone()
+	if err != nil {
+		return nil, "", err
+	}
+	return tpl, tname, nil
+}
+
+func (r *resolver) extends(tname string) []string {
+	return r.parsedTemplate(tname).extends
+}
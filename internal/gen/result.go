@@ -0,0 +1,89 @@
+package gen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phases of a build, in order.
+const PhaseCollections = "collections"
+const PhaseMarkdown = "markdown"
+const PhaseContent = "content"
+
+// Copying static files, when not building in place.
+const PhaseStatic = "static"
+
+// Removing the files of a previous build and writing the manifest.
+const PhaseClean = "clean"
+
+type BuildError struct {
+	// File is the source file (or folder) being processed when the error occurred.
+	File  string
+	Phase string
+	Err   error
+}
+
+func (e BuildError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Phase, e.File, e.Err)
+}
+
+func (e BuildError) Unwrap() error {
+	return e.Err
+}
+
+type BuildResult struct {
+	Errors []BuildError
+	// Written lists the files written by the build, in order.
+	Written []string
+	// Removed lists the files and folders of a previous build removed by the build.
+	Removed  []string
+	Manifest Manifest
+}
+
+func NewBuildResult() *BuildResult {
+	return &BuildResult{make([]BuildError, 0), make([]string, 0), make([]string, 0), newManifest()}
+}
+
+func (r *BuildResult) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+func (r *BuildResult) Err() error {
+	// A single error summarizing the errors of the build, or nil if there are none.
+	switch len(r.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return r.Errors[0]
+	}
+	msgs := make([]string, 0, len(r.Errors))
+	for _, e := range r.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Errorf("%d errors:\n  %s", len(r.Errors), strings.Join(msgs, "\n  "))
+}
+
+func (r *BuildResult) addError(file string, phase string, err error) {
+	r.Errors = append(r.Errors, BuildError{file, phase, err})
+}
+
+func (r *BuildResult) addWritten(file string, src string, templates []string, data []byte) {
+	r.Written = append(r.Written, file)
+	r.Manifest.Files = append(r.Manifest.Files, ManifestEntry{file, src, templates, hashOf(data)})
+}
+
+func (r *BuildResult) addFolder(name string, src string) {
+	r.Manifest.Folders = append(r.Manifest.Folders, ManifestEntry{name, src, nil, ""})
+}
+
+func (r *BuildResult) addRemoved(name string) {
+	r.Removed = append(r.Removed, name)
+}
+
+func (r *BuildResult) merge(t *BuildResult) {
+	r.Errors = append(r.Errors, t.Errors...)
+	r.Written = append(r.Written, t.Written...)
+	r.Removed = append(r.Removed, t.Removed...)
+	r.Manifest.Folders = append(r.Manifest.Folders, t.Manifest.Folders...)
+	r.Manifest.Files = append(r.Manifest.Files, t.Manifest.Files...)
+}
@@ -0,0 +1,300 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/russross/blackfriday/v2"
+	"html/template"
+	"path"
+	"strings"
+)
+
+// Shortcodes are of the form
+//
+//   {{< name arg1 "arg 2" key=value key2="some value" >}}
+//
+// optionally followed by inner content and a closing {{< /name >}}.
+// A shortcode can be explicitly self-closing with {{< name ... />}}.
+// To get a literal shortcode in the output (e.g., in documentation),
+// write {{</* name ... */>}}.
+//
+// Shortcode "name" is rendered using template file name.template found in
+// the shortcodes/ folder of the nearest enclosing __src folder.
+
+type ShortcodeContent struct {
+	Name string
+	// Positional arguments.
+	Args []string
+	// Named arguments.
+	Params map[string]string
+	// Inner content, rendered from markdown. Empty for self-closing shortcodes.
+	Inner template.HTML
+	Site  *Site
+	Data  map[string]interface{}
+}
+
+func (sc ShortcodeContent) Arg(i int) string {
+	if i < 0 || i >= len(sc.Args) {
+		return ""
+	}
+	return sc.Args[i]
+}
+
+func (sc ShortcodeContent) Param(name string) string {
+	return sc.Params[name]
+}
+
+type shortcodeTag struct {
+	name      string
+	args      []string
+	params    map[string]string
+	closing   bool
+	selfClose bool
+	// Literal is set for escaped shortcodes {{</* ... */>}}; text is what's inside the comment.
+	literal bool
+	text    string
+	// Offsets of the tag in the source.
+	start int
+	end   int
+}
+
+const shortcodeOpen = "{{<"
+const shortcodeClose = ">}}"
+
+func (b *Build) ExpandShortcodes(fname string, md []byte) ([]byte, error) {
+	return b.expandShortcodes(fname, md, 0)
+}
+
+func (b *Build) expandShortcodes(fname string, md []byte, line int) ([]byte, error) {
+	// Line is the line offset of md in fname, for error messages.
+	var out bytes.Buffer
+	pos := 0
+	for {
+		tag, found, err := nextShortcodeTag(md, pos)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fname, line+lineNumber(md, tag.start), err)
+		}
+		if !found {
+			out.Write(md[pos:])
+			return out.Bytes(), nil
+		}
+		out.Write(md[pos:tag.start])
+		pos = tag.end
+		if tag.literal {
+			out.WriteString(shortcodeOpen + tag.text + shortcodeClose)
+			continue
+		}
+		if tag.closing {
+			return nil, fmt.Errorf("%s:%d: unexpected closing shortcode %s", fname, line+lineNumber(md, tag.start), tag.name)
+		}
+		var inner []byte
+		if !tag.selfClose {
+			closeTag, found, err := findClosingShortcode(md, tag)
+			if err != nil {
+				// The position of the faulty tag, inside the shortcode.
+				return nil, fmt.Errorf("%s:%d: %s", fname, line+lineNumber(md, closeTag.start), err)
+			}
+			if found {
+				inner, err = b.expandShortcodes(fname, md[tag.end:closeTag.start], line+lineNumber(md, tag.end)-1)
+				if err != nil {
+					return nil, err
+				}
+				pos = closeTag.end
+			}
+		}
+		result, err := b.renderShortcode(fname, tag, inner)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fname, line+lineNumber(md, tag.start), err)
+		}
+		out.WriteString(string(result))
+	}
+}
+
+func (b *Build) renderShortcode(fname string, tag shortcodeTag, inner []byte) (template.HTML, error) {
+	tpl, _, err := b.FindShortcodeTemplate(fname, tag.name)
+	if err != nil {
+		return template.HTML(""), err
+	}
+	data, err := b.LoadData(fname)
+	if err != nil {
+		return template.HTML(""), err
+	}
+	content := ShortcodeContent{tag.name, tag.args, tag.params, template.HTML(""), b.site, data}
+	if inner != nil {
+		content.Inner = template.HTML(blackfriday.Run(inner, blackfriday.WithNoExtensions()))
+	}
+	var sb strings.Builder
+	if err := tpl.Execute(&sb, content); err != nil {
+		return template.HTML(""), err
+	}
+	return template.HTML(sb.String()), nil
+}
+
+func (b *Build) FindShortcodeTemplate(fname string, name string) (*template.Template, string, error) {
+	// Given a path, find the nearest enclosing shortcodes/<name>.template file.
+	if !isTemplateName(name) {
+		return nil, "", fmt.Errorf("invalid shortcode name %q", name)
+	}
+	for _, dir := range parentDirs(fname) {
+		gdPath, err := b.res.genDirPath(dir)
+		if err == nil {
+			sctpl, sctname, err := b.findTemplateFile(path.Join(gdPath, SHORTCODEDIR), name+".template")
+			if err != nil || sctpl != nil {
+				return sctpl, sctname, err
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("no template found for shortcode %s", name)
+}
+
+func findClosingShortcode(md []byte, open shortcodeTag) (shortcodeTag, bool, error) {
+	// Look for the matching {{< /name >}}, accounting for nested shortcodes with the same name.
+	depth := 0
+	pos := open.end
+	for {
+		tag, found, err := nextShortcodeTag(md, pos)
+		if err != nil || !found {
+			return tag, false, err
+		}
+		pos = tag.end
+		if tag.literal || tag.name != open.name {
+			continue
+		}
+		if tag.closing {
+			if depth == 0 {
+				return tag, true, nil
+			}
+			depth--
+		} else if !tag.selfClose {
+			depth++
+		}
+	}
+}
+
+func nextShortcodeTag(md []byte, pos int) (shortcodeTag, bool, error) {
+	// On errors, the start of the returned tag is where the faulty tag starts.
+	idx := bytes.Index(md[pos:], []byte(shortcodeOpen))
+	if idx < 0 {
+		return shortcodeTag{}, false, nil
+	}
+	start := pos + idx
+	bodyStart := start + len(shortcodeOpen)
+	idx = bytes.Index(md[bodyStart:], []byte(shortcodeClose))
+	if idx < 0 {
+		return shortcodeTag{start: start}, false, fmt.Errorf("unterminated shortcode")
+	}
+	end := bodyStart + idx + len(shortcodeClose)
+	body := string(md[bodyStart : bodyStart+idx])
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "/*") && strings.HasSuffix(trimmed, "*/") {
+		// Keep the spacing around the escaped shortcode.
+		text := strings.Replace(body, "/*", "", 1)
+		idx = strings.LastIndex(text, "*/")
+		text = text[:idx] + text[idx+2:]
+		return shortcodeTag{literal: true, text: text, start: start, end: end}, true, nil
+	}
+	tag, err := parseShortcodeTag(trimmed)
+	if err != nil {
+		return shortcodeTag{start: start}, false, err
+	}
+	tag.start = start
+	tag.end = end
+	return tag, true, nil
+}
+
+func parseShortcodeTag(body string) (shortcodeTag, error) {
+	tag := shortcodeTag{params: make(map[string]string)}
+	if strings.HasPrefix(body, "/") {
+		tag.closing = true
+		tag.name = strings.TrimSpace(body[1:])
+		if !isTemplateName(tag.name) {
+			return tag, fmt.Errorf("invalid closing shortcode %q", body)
+		}
+		return tag, nil
+	}
+	if strings.HasSuffix(body, "/") {
+		tag.selfClose = true
+		body = strings.TrimSpace(strings.TrimSuffix(body, "/"))
+	}
+	rest := body
+	first := true
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if rest == "" {
+			break
+		}
+		word, remaining, quoted, err := nextShortcodeToken(rest)
+		if err != nil {
+			return tag, err
+		}
+		if word == "" && !quoted {
+			return tag, fmt.Errorf("unexpected %q in shortcode", rest[:1])
+		}
+		rest = remaining
+		if first {
+			if quoted || !isTemplateName(word) {
+				return tag, fmt.Errorf("invalid shortcode name %q", word)
+			}
+			tag.name = word
+			first = false
+			continue
+		}
+		if !quoted && strings.HasPrefix(rest, "=") {
+			value, remaining, _, err := nextShortcodeToken(rest[1:])
+			if err != nil {
+				return tag, err
+			}
+			rest = remaining
+			tag.params[word] = value
+			continue
+		}
+		tag.args = append(tag.args, word)
+	}
+	if first {
+		return tag, fmt.Errorf("missing shortcode name")
+	}
+	return tag, nil
+}
+
+func nextShortcodeToken(s string) (string, string, bool, error) {
+	// Return the next token, the rest of the string, and whether the token was quoted.
+	if s == "" {
+		return "", "", false, nil
+	}
+	switch s[0] {
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					b.WriteByte(s[i])
+				}
+			case '"':
+				return b.String(), s[i+1:], true, nil
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", "", false, fmt.Errorf("unterminated string in shortcode")
+	case '`':
+		idx := strings.IndexByte(s[1:], '`')
+		if idx < 0 {
+			return "", "", false, fmt.Errorf("unterminated string in shortcode")
+		}
+		return s[1 : idx+1], s[idx+2:], true, nil
+	}
+	end := strings.IndexAny(s, " \t\r\n=")
+	if end < 0 {
+		return s, "", false, nil
+	}
+	return s[:end], s[end:], false, nil
+}
+
+func lineNumber(src []byte, pos int) int {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	return bytes.Count(src[:pos], []byte("\n")) + 1
+}
@@ -0,0 +1,104 @@
+package gen
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseShortcodeTag(t *testing.T) {
+	tests := []struct {
+		body string
+		want shortcodeTag
+		err  string
+	}{
+		{`youtube abc123`, shortcodeTag{name: "youtube", args: []string{"abc123"}, params: map[string]string{}}, ""},
+		{`figure src=x.png caption="A \"quoted\" caption"`, shortcodeTag{name: "figure", params: map[string]string{"src": "x.png", "caption": `A "quoted" caption`}}, ""},
+		{"note `raw \\ string` \"arg 2\"", shortcodeTag{name: "note", args: []string{`raw \ string`, "arg 2"}, params: map[string]string{}}, ""},
+		{`br /`, shortcodeTag{name: "br", selfClose: true, params: map[string]string{}}, ""},
+		{`/note`, shortcodeTag{name: "note", closing: true, params: map[string]string{}}, ""},
+		{`/ note`, shortcodeTag{name: "note", closing: true, params: map[string]string{}}, ""},
+		{``, shortcodeTag{}, "missing shortcode name"},
+		{`"quoted" x`, shortcodeTag{}, "invalid shortcode name"},
+		{`../up`, shortcodeTag{}, "invalid shortcode name"},
+		{`/`, shortcodeTag{}, "invalid closing shortcode"},
+		{`figure caption="unterminated`, shortcodeTag{}, "unterminated string"},
+		{"figure `unterminated", shortcodeTag{}, "unterminated string"},
+		{`figure =x`, shortcodeTag{}, "unexpected \"=\""},
+	}
+	for _, test := range tests {
+		tag, err := parseShortcodeTag(test.body)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseShortcodeTag(%q): got error %v, want %q", test.body, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseShortcodeTag(%q): %s", test.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(tag, test.want) {
+			t.Errorf("parseShortcodeTag(%q) = %+v, want %+v", test.body, tag, test.want)
+		}
+	}
+}
+
+func newTestBuild(t *testing.T, fsys fstest.MapFS) *Build {
+	t.Helper()
+	site, err := LoadSite(fsys, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBuild(fsys, NewMemOutput(), site, false, NewTextLogger(io.Discard, LevelError))
+}
+
+func TestExpandShortcodes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"__src/shortcodes/yt.template":   {Data: []byte(`<iframe src="{{.Arg 0}}"></iframe>`)},
+		"__src/shortcodes/note.template": {Data: []byte(`<div class="{{.Param "class"}}">{{.Inner}}</div>`)},
+		"__src/shortcodes/br.template":   {Data: []byte(`<br>`)},
+	}
+	tests := []struct {
+		md   string
+		want string
+		err  string
+	}{
+		{"no shortcodes", "no shortcodes", ""},
+		{"a {{< yt abc >}} b", `a <iframe src="abc"></iframe> b`, ""},
+		{"{{< br />}}", "<br>", ""},
+		{"{{< note class=x >}}*hi*{{< /note >}}", "<div class=\"x\"><p><em>hi</em></p>\n</div>", ""},
+		// Shortcodes in the inner content are expanded first.
+		{"{{< note >}}{{< yt z >}}{{< /note >}}", "<div class=\"\"><p><iframe src=\"z\"></iframe></p>\n</div>", ""},
+		// Nested shortcodes with the same name.
+		{"{{< note class=a >}}{{< note class=b >}}x{{< /note >}}{{< /note >}}", "<div class=\"a\"><p><div class=\"b\"><p>x</p>\n</div></p>\n</div>", ""},
+		// Without a closing tag, a shortcode has no inner content.
+		{"{{< note >}} rest", `<div class=""></div> rest`, ""},
+		{"{{</* yt abc */>}}", "{{< yt abc >}}", ""},
+		{"{{</*yt*/>}}", "{{<yt>}}", ""},
+		{"{{< note >}}{{</* /note */>}}{{< /note >}}", "<div class=\"\"><p>{{&lt; /note &gt;}}</p>\n</div>", ""},
+		{"line\n{{< yt abc", "", "x.md:2: unterminated shortcode"},
+		{"{{< /note >}}", "", "x.md:1: unexpected closing shortcode note"},
+		{"\n\n{{< missing >}}", "", "x.md:3: no template found for shortcode missing"},
+		{"{{< note >}}\n\n{{< yt \"x >}}{{< /note >}}", "", "x.md:3: unterminated string"},
+	}
+	b := newTestBuild(t, fsys)
+	for _, test := range tests {
+		result, err := b.ExpandShortcodes("x.md", []byte(test.md))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ExpandShortcodes(%q): got error %v, want %q", test.md, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ExpandShortcodes(%q): %s", test.md, err)
+			continue
+		}
+		if string(result) != test.want {
+			t.Errorf("ExpandShortcodes(%q) = %q, want %q", test.md, result, test.want)
+		}
+	}
+}
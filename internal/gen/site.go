@@ -0,0 +1,262 @@
+package gen
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// Site is available as .Site in every template.
+//
+// The site is loaded before generating anything: the sources of all pages
+// and collection items, along with the data files, are read once and kept
+// with the site, and the build generates files from them.
+
+type Site struct {
+	Title     string
+	BaseURL   string
+	Author    string
+	BuildTime time.Time
+	Params    map[string]interface{}
+	// All pages of the site (excluding posts), ordered by URL.
+	Pages []PageInfo
+	// All posts of the site, most recent first.
+	Posts []PageInfo
+	// All items of all collections, by collection name, in collection order.
+	// Includes POSTS.
+	Collections map[string][]PageInfo
+
+	collections []Collection
+	sources     *sourceFiles
+	// Items of the collection folders, by folder.
+	items map[string][]PostInfo
+	// Content of the data folders, by folder.
+	data map[string]map[string]interface{}
+	// Pages and collection items, by URL.
+	pages map[string]*PageInfo
+	// Whether collection items marked as drafts are included.
+	drafts bool
+}
+
+type PageInfo struct {
+	Title         string
+	URL           string
+	Date          time.Time
+	FormattedDate string
+	// Key and Collection are only set for posts and other collection items.
+	Key        string
+	Collection string
+	Params     map[string]string
+	// Source is the source file of the page.
+	Source string
+	// Prev and Next are the neighbouring items of a collection item in the
+	// same collection folder, in the order of the collection, or nil.
+	Prev *PageInfo
+	Next *PageInfo
+}
+
+// Page returns the page or collection item with the given URL, or nil.
+
+func (site *Site) Page(url string) *PageInfo {
+	return site.pages[url]
+}
+
+// PageBySource returns the page or collection item generated from the given
+// source file, or nil.
+
+func (site *Site) PageBySource(source string) *PageInfo {
+	for _, page := range site.pages {
+		if page.Source == source {
+			return page
+		}
+	}
+	return nil
+}
+
+func (site *Site) removePage(source string) {
+	// Leave out the page generated from source.
+	pages := make([]PageInfo, 0, len(site.Pages))
+	for _, p := range site.Pages {
+		if p.Source == source {
+			delete(site.pages, p.URL)
+		} else {
+			pages = append(pages, p)
+		}
+	}
+	site.Pages = pages
+	for i := range site.Pages {
+		site.pages[site.Pages[i].URL] = &site.Pages[i]
+	}
+}
+
+func LoadSite(fsys fs.FS, config Config) (*Site, error) {
+	collections := config.AllCollections()
+	site := &Site{config.Title, config.BaseURL, config.Author, time.Now(), config.Params, make([]PageInfo, 0), make([]PageInfo, 0), make(map[string][]PageInfo), collections, newSourceFiles(fsys), make(map[string][]PostInfo), make(map[string]map[string]interface{}), make(map[string]*PageInfo), config.Drafts}
+	for _, coll := range collections {
+		site.Collections[coll.Name] = make([]PageInfo, 0)
+	}
+	// Items of the same collection folder, for linking them.
+	type itemRange struct {
+		name       string
+		start, end int
+	}
+	ranges := make([]itemRange, 0)
+	// Generated collection folders are skipped: items are read from their sources.
+	skipped := make(map[string]bool)
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Error in processing the path - skip.
+			return nil
+		}
+		if !d.IsDir() {
+			// Skip over files.
+			return nil
+		}
+		if isSkippedDirectory(p) || skipped[p] {
+			return fs.SkipDir
+		}
+		if err := site.loadData(p); err != nil {
+			return err
+		}
+		pages, err := site.loadPages(p)
+		if err != nil {
+			return err
+		}
+		site.Pages = append(site.Pages, pages...)
+		for _, coll := range collections {
+			items, err := site.loadCollection(p, coll)
+			if err != nil {
+				return err
+			}
+			if items != nil {
+				start := len(site.Collections[coll.Name])
+				site.Collections[coll.Name] = append(site.Collections[coll.Name], items...)
+				ranges = append(ranges, itemRange{coll.Name, start, len(site.Collections[coll.Name])})
+				skipped[path.Join(p, coll.Output)] = true
+			}
+		}
+		return nil
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(site.Pages, func(i, j int) bool { return site.Pages[i].URL < site.Pages[j].URL })
+	for _, r := range ranges {
+		items := site.Collections[r.name]
+		for i := r.start; i < r.end; i++ {
+			if i > r.start {
+				items[i].Prev = &items[i-1]
+			}
+			if i < r.end-1 {
+				items[i].Next = &items[i+1]
+			}
+		}
+	}
+	for i := range site.Pages {
+		site.pages[site.Pages[i].URL] = &site.Pages[i]
+	}
+	for _, coll := range collections {
+		items := site.Collections[coll.Name]
+		for i := range items {
+			site.pages[items[i].URL] = &items[i]
+		}
+	}
+	site.Posts = site.Collections[GENPOSTS]
+	return site, nil
+}
+
+func (site *Site) loadData(dir string) error {
+	gdPath, err := identifyGenDirPath(site.sources.fsys, dir)
+	if err != nil {
+		return nil
+	}
+	dataDir := path.Join(gdPath, DATADIR)
+	if fi, err := fs.Stat(site.sources.fsys, dataDir); err != nil || !fi.IsDir() {
+		return nil
+	}
+	data, err := loadDataDir(site.sources.fsys, dataDir)
+	if err != nil {
+		return err
+	}
+	site.data[dataDir] = data
+	return nil
+}
+
+func (site *Site) loadPages(dir string) ([]PageInfo, error) {
+	fsys := site.sources.fsys
+	gdPath, err := identifyGenDirPath(fsys, dir)
+	if err != nil {
+		return nil, nil
+	}
+	entries, err := fs.ReadDir(fsys, gdPath)
+	if err != nil {
+		// if we can't read GENDIR, skip.
+		return nil, nil
+	}
+	// A .content file generated from a .md file is the same page.
+	markdowns := make(map[string]bool)
+	for _, d := range entries {
+		if !d.IsDir() && IsMarkdown(d.Name()) {
+			markdowns[targetFilename(d.Name(), "md", "content")] = true
+		}
+	}
+	pages := make([]PageInfo, 0)
+	for _, coll := range site.collections {
+		if _, err := identifyGenCollection(fsys, dir, coll.Name); err != nil {
+			continue
+		}
+		// The summary of the collection might not have been generated yet.
+		if _, err := fs.Stat(fsys, path.Join(gdPath, coll.Index)); errors.Is(err, fs.ErrNotExist) {
+			url := siteURL(path.Join(dir, targetFilename(coll.Index, "content", "html")))
+			pages = append(pages, PageInfo{"", url, time.Time{}, FormatDate(time.Time{}), "", "", nil, path.Join(gdPath, coll.Index), nil, nil})
+		}
+	}
+	for _, d := range entries {
+		if d.IsDir() || markdowns[d.Name()] {
+			continue
+		}
+		var target string
+		if IsMarkdown(d.Name()) {
+			target = targetFilename(d.Name(), "md", "html")
+		} else if IsContent(d.Name()) {
+			target = targetFilename(d.Name(), "content", "html")
+		} else {
+			continue
+		}
+		fname := path.Join(gdPath, d.Name())
+		source, err := site.sources.load(fname)
+		if err != nil {
+			return nil, err
+		}
+		metadata := source.metadata
+		url := siteURL(path.Join(dir, target))
+		pages = append(pages, PageInfo{metadata.Title, url, metadata.Date, FormatDate(metadata.Date), "", "", metadata.Params, fname, nil, nil})
+	}
+	return pages, nil
+}
+
+func (site *Site) loadCollection(dir string, coll Collection) ([]PageInfo, error) {
+	genColl, err := identifyGenCollection(site.sources.fsys, dir, coll.Name)
+	if err != nil {
+		return nil, nil
+	}
+	collPath := path.Join(dir, genColl)
+	posts, err := extractCollection(site.sources, collPath, coll, site.drafts)
+	if err != nil {
+		return nil, err
+	}
+	site.items[collPath] = posts
+	result := make([]PageInfo, 0, len(posts))
+	for _, post := range posts {
+		url := siteURL(path.Join(dir, coll.Output, post.Key, "index.html"))
+		result = append(result, PageInfo{post.Title, url, post.Date, FormatDate(post.Date), post.Key, coll.Name, post.Params, path.Join(collPath, post.Source, POSTMD), nil, nil})
+	}
+	return result, nil
+}
+
+func siteURL(target string) string {
+	// URL of a target file, relative to the root of the site.
+	return path.Join("/", target)
+}
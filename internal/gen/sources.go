@@ -0,0 +1,55 @@
+package gen
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// The sources of the pages and collection items of a site are read and
+// parsed once, when loading the site, and kept with the site for the build.
+
+type sourceFile struct {
+	metadata Metadata
+	// Src is the whole file, and body the part following the front matter.
+	src  []byte
+	body []byte
+}
+
+type sourceFiles struct {
+	fsys  fs.FS
+	mu    sync.Mutex
+	files map[string]*sourceFile
+}
+
+func newSourceFiles(fsys fs.FS) *sourceFiles {
+	return &sourceFiles{fsys, sync.Mutex{}, make(map[string]*sourceFile)}
+}
+
+func (s *sourceFiles) load(name string) (*sourceFile, error) {
+	s.mu.Lock()
+	source, ok := s.files[name]
+	s.mu.Unlock()
+	if ok {
+		return source, nil
+	}
+	src, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	source, err = parseSource(src)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.files[name] = source
+	s.mu.Unlock()
+	return source, nil
+}
+
+func parseSource(src []byte) (*sourceFile, error) {
+	metadata, body, err := ExtractMetadata(src)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceFile{metadata, src, body}, nil
+}
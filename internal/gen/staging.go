@@ -0,0 +1,152 @@
+package gen
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+)
+
+// The output folders of collections are regenerated aside when the output
+// supports it (see StagingOutput), and swapped in at the end of the build,
+// only if no errors were reported for the collection or its items.
+// Otherwise, the previous folder is kept as is.
+
+type stagedOutput struct {
+	Output
+	stages []stage
+}
+
+type stage struct {
+	// Name is the staged folder, and src the collection folder generating it.
+	name string
+	src  string
+	out  Output
+}
+
+func (o *stagedOutput) route(name string) (Output, string) {
+	// The output for name, and the name relative to that output.
+	for _, s := range o.stages {
+		if isUnder(name, s.name) {
+			rel := strings.TrimPrefix(strings.TrimPrefix(name, s.name), "/")
+			if rel == "" {
+				rel = "."
+			}
+			return s.out, rel
+		}
+	}
+	return o.Output, name
+}
+
+func (o *stagedOutput) Create(name string) (io.WriteCloser, error) {
+	out, rel := o.route(name)
+	return out.Create(rel)
+}
+
+func (o *stagedOutput) MkdirAll(name string) error {
+	out, rel := o.route(name)
+	return out.MkdirAll(rel)
+}
+
+func (o *stagedOutput) RemoveAll(name string) error {
+	out, rel := o.route(name)
+	return out.RemoveAll(rel)
+}
+
+func (o *stagedOutput) stage(name string, src string) error {
+	staging, ok := o.Output.(StagingOutput)
+	if !ok {
+		// Regenerate in place.
+		if err := o.Output.RemoveAll(name); err != nil {
+			return err
+		}
+		return o.Output.MkdirAll(name)
+	}
+	out, err := staging.Stage(name)
+	if err != nil {
+		return err
+	}
+	o.stages = append(o.stages, stage{name, src, out})
+	return nil
+}
+
+func (b *Build) stageDir(name string, src string) error {
+	// Regenerate folder name from collection folder src.
+	if err := b.checkStage(name, src); err != nil {
+		return err
+	}
+	b.fsys.remove(name)
+	if !b.isOutput(name) {
+		return nil
+	}
+	b.result.addFolder(name, src)
+	kept := b.handFiles(name, src)
+	return b.toOutput(name, PhaseCollections, func(out Output) error {
+		// Files added by hand are read before staging, since a folder
+		// regenerated in place is removed first.
+		data := make([][]byte, len(kept))
+		for i, fname := range kept {
+			content, err := b.out.Output.(ReadableOutput).ReadFile(fname)
+			if err != nil {
+				return err
+			}
+			data[i] = content
+		}
+		if err := b.out.stage(name, src); err != nil {
+			return err
+		}
+		for i, fname := range kept {
+			if err := out.MkdirAll(path.Dir(fname)); err != nil {
+				return err
+			}
+			if err := writeOutput(out, fname, data[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Build) commitStages(ctx context.Context) {
+	staging, ok := b.out.Output.(StagingOutput)
+	if !ok {
+		return
+	}
+	for _, s := range b.out.stages {
+		failed := ctx.Err() != nil
+		for _, e := range b.result.Errors {
+			if isUnder(e.File, s.name) || isUnder(e.File, s.src) {
+				failed = true
+			}
+		}
+		if failed {
+			b.log.Log(LevelInfo, "keeping previous folder after errors", Fields{"file": s.name, "source": s.src})
+			if err := staging.Discard(s.out); err != nil {
+				b.reportError(s.name, PhaseCollections, err)
+			}
+			b.result.Manifest.Files = filterUnder(b.result.Manifest.Files, s.name)
+			continue
+		}
+		if err := staging.Commit(s.name, s.out); err != nil {
+			if discardErr := staging.Discard(s.out); discardErr != nil {
+				b.reportError(s.name, PhaseCollections, discardErr)
+			}
+			b.result.Manifest.Files = filterUnder(b.result.Manifest.Files, s.name)
+			b.reportError(s.name, PhaseCollections, err)
+			continue
+		}
+		b.log.Log(LevelVerbose, "replaced folder", Fields{"file": s.name, "source": s.src})
+	}
+	b.out.stages = nil
+}
+
+func filterUnder(entries []ManifestEntry, dir string) []ManifestEntry {
+	// The files of a discarded folder were never written.
+	result := make([]ManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		if !isUnder(e.File, dir) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package gen
+
+import (
+	"context"
+	"sync"
+)
+
+// The files of a phase are generated by tasks running on a pool of workers.
+// A task runs on its own fork of the build, which records its errors, log
+// messages, and writes to the output. Tasks are merged back into the build
+// in the order in which they were collected, so that the output, the result,
+// and the log do not depend on the order in which tasks complete.
+// Tasks of a phase must be independent: they can read files generated by
+// earlier phases, but not files generated by other tasks of the same phase.
+
+type task func(t *Build)
+
+func (b *Build) fork() *Build {
+	return &Build{b.fsys, b.res, b.out, b.site, NewBuildResult(), b.inPlace, &logBuffer{}, 1, b.own, b.manifest, true, nil}
+}
+
+func (b *Build) runTasks(ctx context.Context, tasks []task) {
+	forks := make([]*Build, len(tasks))
+	done := make([]chan struct{}, len(tasks))
+	for i := range tasks {
+		forks[i] = b.fork()
+		done[i] = make(chan struct{})
+	}
+	jobs := make(chan int)
+	go func() {
+		for i := range tasks {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < b.workers && w < len(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() == nil {
+					tasks[i](forks[i])
+				}
+				close(done[i])
+			}
+		}()
+	}
+	for i := range tasks {
+		<-done[i]
+		b.merge(forks[i])
+	}
+	wg.Wait()
+}
+
+func (b *Build) merge(t *Build) {
+	t.log.(*logBuffer).replay(b.log)
+	b.result.merge(t.result)
+	for _, p := range t.pending {
+		if err := b.toOutput(p.name, p.phase, p.apply); err != nil {
+			b.reportError(p.name, p.phase, err)
+		}
+	}
+}
+
+// A logBuffer records the messages of a task.
+
+type logBuffer struct {
+	entries []logEntry
+}
+
+type logEntry struct {
+	level  Level
+	msg    string
+	fields Fields
+}
+
+func (l *logBuffer) Log(level Level, msg string, fields Fields) {
+	l.entries = append(l.entries, logEntry{level, msg, fields})
+}
+
+func (l *logBuffer) replay(logger Logger) {
+	for _, e := range l.entries {
+		logger.Log(e.level, e.msg, e.fields)
+	}
+}
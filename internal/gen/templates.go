@@ -0,0 +1,120 @@
+package gen
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A template file can extend another template by starting with
+//
+//   {{/* extends "base" */}}
+//
+// The parent base.template is looked up in the nearest enclosing __src
+// folder, starting with the folder of the extending template itself.
+// The extending template redefines some of the blocks of its parent with
+// {{define "name"}}...{{end}}, and the result is the parent with those
+// blocks replaced. Parents can themselves extend other templates.
+
+var extendsRegexp = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*extends\s+"([^"]*)"\s*\*/\s*-?\}\}`)
+
+func extendsDirective(src []byte) (string, bool) {
+	match := extendsRegexp.FindSubmatch(src)
+	if match == nil {
+		return "", false
+	}
+	return string(match[1]), true
+}
+
+func (r *resolver) parseTemplateFile(tname string) (*template.Template, []string, error) {
+	// Collect the inheritance chain, from tname up to the root template.
+	chain := make([]string, 0)
+	sources := make([]string, 0)
+	seen := make(map[string]bool)
+	current := tname
+	for {
+		if seen[current] {
+			return nil, nil, fmt.Errorf("%s: template inheritance cycle through %s", tname, current)
+		}
+		seen[current] = true
+		src, err := fs.ReadFile(r.fsys, current)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, current)
+		sources = append(sources, string(src))
+		parent, ok := extendsDirective(src)
+		if !ok {
+			break
+		}
+		current, err = r.findParentTemplate(current, parent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", chain[len(chain)-1], err)
+		}
+	}
+	// Parse from the root down so that nearer templates redefine the blocks of farther ones.
+	// The extending templates are parsed as separate associated templates,
+	// so that only their definitions matter.
+	root := len(chain) - 1
+	tpl, err := template.New(path.Base(chain[root])).Parse(sources[root])
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := root - 1; i >= 0; i-- {
+		if _, err := tpl.New(chain[i]).Parse(sources[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tpl, chain[1:], nil
+}
+
+func (r *resolver) findParentTemplate(tname string, name string) (string, error) {
+	// Given the path of an extending template, find the nearest enclosing parent template,
+	// skipping the extending template itself.
+	if !isTemplateName(name) {
+		return "", fmt.Errorf("invalid parent template name %q", name)
+	}
+	if !strings.HasSuffix(name, ".template") {
+		name = name + ".template"
+	}
+	for _, dir := range parentDirs(tname) {
+		gdPath, err := r.genDirPath(dir)
+		if err == nil {
+			ptname := path.Join(gdPath, name)
+			if _, err := fs.Stat(r.fsys, ptname); err == nil && ptname != tname {
+				return ptname, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("parent template %s not found", name)
+}
+
+func (b *Build) findTemplateFile(gdPath string, name string) (*template.Template, string, error) {
+	tpl, tname, err := b.res.findTemplateFile(gdPath, name)
+	if tpl != nil {
+		fields := Fields{"template": tname}
+		if extends := b.res.extends(tname); len(extends) > 0 {
+			fields["extends"] = strings.Join(extends, ", ")
+		}
+		b.log.Log(LevelDebug, "found template", fields)
+	} else if err == nil {
+		b.log.Log(LevelDebug, "no template", Fields{"template": path.Join(gdPath, name)})
+	}
+	return tpl, tname, err
+}
+
+func (b *Build) searchDir(dir string) (string, bool) {
+	// The __src folder of dir, when looking for templates in the folders enclosing a file.
+	gdPath, err := b.res.genDirPath(dir)
+	if err != nil {
+		if !isGenDir(dir) {
+			b.log.Log(LevelDebug, "no __src folder", Fields{"file": dir})
+		}
+		return "", false
+	}
+	b.log.Log(LevelDebug, "searching", Fields{"file": gdPath})
+	return gdPath, true
+}
@@ -1,15 +1,15 @@
 package gen
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 )
 
-func isGenDir(path string) bool {
-	base := filepath.Base(path)
+func isGenDir(name string) bool {
+	base := path.Base(name)
 	if base == GENDIR {
 		return true
 	}
@@ -19,8 +19,8 @@ func isGenDir(path string) bool {
 	return false
 }
 
-func isGenPosts(path string) bool {
-	base := filepath.Base(path)
+func isGenPosts(name string) bool {
+	base := path.Base(name)
 	if base == GENPOSTS {
 		return true
 	}
@@ -30,23 +30,26 @@ func isGenPosts(path string) bool {
 	return false
 }
 
-func isSkippedDirectory(path string) bool {
-	if filepath.Base(path) == ".git" {
+func isSkippedDirectory(name string) bool {
+	if path.Base(name) == ".git" {
 		return true
 	}
-	if isGenDir(path) {
+	if strings.HasPrefix(path.Base(name), tempPrefix) {
 		return true
 	}
-	if isGenPosts(path) {
+	if isGenDir(name) {
+		return true
+	}
+	if isGenPosts(name) {
 		return true
 	}
 	return false
 }
 
-func identifyGenDir(path string) (string, error) {
-	fileinfo, err := os.Stat(filepath.Join(path, GENDIR))
+func identifyGenDir(fsys fs.FS, dir string) (string, error) {
+	fileinfo, err := fs.Stat(fsys, path.Join(dir, GENDIR))
 	if err != nil {
-		fileinfo, err := os.Stat(filepath.Join(path, "."+GENDIR))
+		fileinfo, err := fs.Stat(fsys, path.Join(dir, "."+GENDIR))
 		if err != nil {
 			return "", err
 		}
@@ -61,88 +64,56 @@ func identifyGenDir(path string) (string, error) {
 	return "", fmt.Errorf("GENDIR not a directory")
 }
 
-func identifyGenPosts(path string) (string, error) {
-	fileinfo, err := os.Stat(filepath.Join(path, GENDIR, GENPOSTS))
+func identifyGenPosts(fsys fs.FS, dir string) (string, error) {
+	return identifyGenCollection(fsys, dir, GENPOSTS)
+}
+
+func identifyGenCollection(fsys fs.FS, dir string, name string) (string, error) {
+	fileinfo, err := fs.Stat(fsys, path.Join(dir, GENDIR, name))
 	if err != nil {
-		fileinfo, err := os.Stat(filepath.Join(path, "."+GENDIR, GENPOSTS))
+		fileinfo, err := fs.Stat(fsys, path.Join(dir, "."+GENDIR, name))
 		if err != nil {
 			return "", err
 		}
 		if fileinfo.IsDir() {
-			return filepath.Join("."+GENDIR, GENPOSTS), nil
+			return path.Join("."+GENDIR, name), nil
 		}
-		return "", fmt.Errorf("GENPOSTS not a directory")
+		return "", fmt.Errorf("%s not a directory", name)
 	}
 	if fileinfo.IsDir() {
-		return filepath.Join(GENDIR, GENPOSTS), nil
+		return path.Join(GENDIR, name), nil
 	}
-	return "", fmt.Errorf("GENPOSTS not a directory")
+	return "", fmt.Errorf("%s not a directory", name)
 }
 
-func identifyGenDirPath(path string) (string, error) {
-	genDir, err := identifyGenDir(path)
+func identifyGenDirPath(fsys fs.FS, dir string) (string, error) {
+	genDir, err := identifyGenDir(fsys, dir)
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(path, genDir), nil
+	return path.Join(dir, genDir), nil
 }
 
-func WalkAndProcessContents(root string) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		rep.Fatal("ERROR: %s\n", err)
-	}
-	walk := func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			// Error in processing the path - skip.
-			return nil
-		}
-		if !d.IsDir() {
-			// Skip over files.
-			return nil
-		}
-		if isSkippedDirectory(path) {
-			return fs.SkipDir
-		}
-		ProcessFilesContent(cwd, path)
-		return nil
-	}
-	if err := filepath.WalkDir(root, walk); err != nil {
-		rep.Fatal("ERROR: %s\n", err)
-	}
+func (b *Build) WalkAndProcessContents(ctx context.Context, root string) error {
+	return b.walkAndProcess(ctx, root, b.contentTasks)
 }
 
-func WalkAndProcessMarkdowns(root string) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		rep.Fatal("ERROR: %s\n", err)
-	}
-	walk := func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			// Error in processing the path - skip.
-			return nil
-		}
-		if !d.IsDir() {
-			// Skip over files.
-			return nil
-		}
-		if isSkippedDirectory(path) {
-			return fs.SkipDir
-		}
-		ProcessFilesMarkdown(cwd, path)
-		return nil
-	}
-	if err := filepath.WalkDir(root, walk); err != nil {
-		rep.Fatal("ERROR: %s\n", err)
-	}
+func (b *Build) WalkAndProcessMarkdowns(ctx context.Context, root string) error {
+	return b.walkAndProcess(ctx, root, b.markdownTasks)
 }
 
-func WalkAndProcessPosts(root string) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		rep.Fatal("ERROR: %s\n", err)
-	}
-	walk := func(path string, d fs.DirEntry, err error) error {
+func (b *Build) WalkAndProcessCollections(ctx context.Context, root string) error {
+	return b.walkAndProcess(ctx, root, b.collectionTasks)
+}
+
+func (b *Build) walkAndProcess(ctx context.Context, root string, tasksOf func(dir string) []task) error {
+	// Collect the tasks of all folders first, then run them.
+	b.res.resetGenDirs()
+	tasks := make([]task, 0)
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
 			// Error in processing the path - skip.
 			return nil
@@ -151,15 +122,17 @@ func WalkAndProcessPosts(root string) {
 			// Skip over files.
 			return nil
 		}
-		if isSkippedDirectory(path) {
+		if isSkippedDirectory(p) {
 			return fs.SkipDir
 		}
-		ProcessFilesPosts(cwd, path)
+		tasks = append(tasks, tasksOf(p)...)
 		return nil
 	}
-	if err := filepath.WalkDir(root, walk); err != nil {
-		rep.Fatal("ERROR: %s\n", err)
+	if err := fs.WalkDir(b.fsys, root, walk); err != nil {
+		return err
 	}
+	b.runTasks(ctx, tasks)
+	return ctx.Err()
 }
 
 func targetFilename(src string, srcSuffix string, tgtSuffix string) string {
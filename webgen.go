@@ -0,0 +1,436 @@
+// Package webgen generates static sites.
+//
+// Sources live in __src (or .__src) folders: a file page.content or page.md
+// in folder/__src is generated into folder/page.html using the templates
+// found in the enclosing __src folders.
+//
+// A minimal use:
+//
+//	b, err := webgen.New(webgen.Options{Root: "site"})
+//	if err != nil { ... }
+//	result, err := b.Build(context.Background())
+//	if err != nil { ... }
+//	if result.Failed() { ... }
+//
+// Sites can also be built from any fs.FS (an embed.FS, a zip archive,
+// an fstest.MapFS) into any Output, e.g., into a zip archive:
+//
+//	out := webgen.NewZipOutput(w)
+//	b, err := webgen.New(webgen.Options{Source: siteFS, Output: out})
+//	...
+//	result, err := b.Build(context.Background())
+//	...
+//	err = out.Close()
+package webgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"rpucella.net/webgen/internal/gen"
+	"sort"
+	"strings"
+)
+
+// BuildResult lists the files written by a build, its errors, and its manifest.
+type BuildResult = gen.BuildResult
+
+// BuildError is an error while generating a file, with the file and the phase of the build.
+type BuildError = gen.BuildError
+
+// Config is the configuration of a site, as read from webgen.toml.
+type Config = gen.Config
+
+// Site is available as .Site in every template.
+type Site = gen.Site
+
+// Page describes a page or collection item of a site.
+type Page = gen.PageInfo
+
+// TemplateFile is a template file that applies to a page, with the template files it extends.
+type TemplateFile = gen.TemplateFile
+
+// Manifest lists the files generated by a build, with their source and templates.
+type Manifest = gen.Manifest
+
+// ManifestEntry is a file or folder of a manifest.
+type ManifestEntry = gen.ManifestEntry
+
+// Difference is a generated file that differs from the file in the output.
+type Difference = gen.Difference
+
+// Explanation tells how the templates of a file are found.
+type Explanation = gen.Explanation
+
+// Output is where generated files are written.
+type Output = gen.Output
+
+// DirOutput writes generated files to a folder.
+type DirOutput = gen.DirOutput
+
+// MemOutput keeps generated files in memory.
+type MemOutput = gen.MemOutput
+
+// ZipOutput writes generated files to a zip archive.
+type ZipOutput = gen.ZipOutput
+
+// TarOutput writes generated files to a tar stream.
+type TarOutput = gen.TarOutput
+
+// Logger receives progress and error messages, with a level and structured fields.
+type Logger = gen.Logger
+
+// Level of a message. Loggers drop the messages above their level.
+type Level = gen.Level
+
+// Fields of a message, such as the file being generated.
+type Fields = gen.Fields
+
+// Levels of messages, from the most to the least important. LevelQuiet only
+// keeps errors and warnings.
+const (
+	LevelError   = gen.LevelError
+	LevelWarning = gen.LevelWarning
+	LevelInfo    = gen.LevelInfo
+	LevelVerbose = gen.LevelVerbose
+	LevelDebug   = gen.LevelDebug
+	LevelQuiet   = gen.LevelQuiet
+)
+
+// NewTextLogger writes one line per message up to level to w, with the fields as key=value.
+func NewTextLogger(w io.Writer, level Level) Logger {
+	return gen.NewTextLogger(w, level)
+}
+
+// NewJSONLogger writes one JSON object per message up to level to w.
+func NewJSONLogger(w io.Writer, level Level) Logger {
+	return gen.NewJSONLogger(w, level)
+}
+
+// NewDirOutput writes generated files to folder dir.
+func NewDirOutput(dir string) *DirOutput {
+	return gen.NewDirOutput(dir)
+}
+
+// NewMemOutput keeps generated files in memory, in its Files map.
+func NewMemOutput() *MemOutput {
+	return gen.NewMemOutput()
+}
+
+// NewZipOutput writes a zip archive to w. Close the output to complete the archive.
+func NewZipOutput(w io.Writer) *ZipOutput {
+	return gen.NewZipOutput(w)
+}
+
+// NewTarOutput writes a tar stream to w. Close the output to complete the stream.
+func NewTarOutput(w io.Writer) *TarOutput {
+	return gen.NewTarOutput(w)
+}
+
+// Options of a Builder. The zero value builds the site in the current folder in place.
+type Options struct {
+	// Root folder of the site. Defaults to the current folder.
+	// Templates, data files and shortcodes are only looked up under it.
+	Root string
+	// Source of the site, e.g., an embed.FS or a zip archive. Defaults to the root folder.
+	Source fs.FS
+	// Output folder. Defaults to the root folder, that is, the site is generated in place.
+	// Otherwise, the site is generated into the output folder, along with the
+	// static files of the site, and without the __src folders.
+	Out string
+	// Output for the generated files, in place of an output folder.
+	Output Output
+	// Configuration file, which must exist. Defaults to webgen.toml in the
+	// source, if there is one.
+	Config string
+	// Logger for progress and error messages. Defaults to logging text to standard error.
+	Logger Logger
+	// Number of files generated in parallel. Defaults to GOMAXPROCS.
+	Workers int
+	// File listing the generated files, used to remove them when they are no
+	// longer generated. Defaults to .webgen-manifest.json in the output. With
+	// an output folder, defaults to .webgen-manifest-<name>.json next to the
+	// output folder <name>, so that it is not published with the site.
+	Manifest string
+	// Build collection items marked as drafts, which are left out by default.
+	Drafts bool
+}
+
+// Builder builds a site. Create one with New.
+type Builder struct {
+	src     fs.FS
+	out     Output
+	inPlace bool
+	// Folder of the source to leave out of the build, e.g., the output folder.
+	exclude string
+	config  Config
+	log     Logger
+	workers int
+	// Manifest file, or "" for the root of the output.
+	manifest string
+}
+
+// New returns a builder for the site described by opts, after reading its
+// configuration file.
+func New(opts Options) (*Builder, error) {
+	root := opts.Root
+	if root == "" {
+		root = "."
+	}
+	src := opts.Source
+	if src == nil {
+		src = os.DirFS(root)
+	}
+	out := opts.Output
+	inPlace := false
+	exclude := ""
+	if out == nil {
+		if opts.Out == "" {
+			out = gen.NewDirOutput(root)
+			inPlace = true
+		} else {
+			absRoot, err := filepath.Abs(root)
+			if err != nil {
+				return nil, err
+			}
+			absOut, err := filepath.Abs(opts.Out)
+			if err != nil {
+				return nil, err
+			}
+			rel, err := filepath.Rel(absRoot, absOut)
+			if err != nil {
+				return nil, err
+			}
+			out = gen.NewDirOutput(opts.Out)
+			if rel != "." && opts.Manifest == "" {
+				opts.Manifest = filepath.Join(filepath.Dir(absOut), strings.TrimSuffix(gen.MANIFEST, ".json")+"-"+filepath.Base(absOut)+".json")
+			}
+			if rel == "." {
+				inPlace = true
+			} else if opts.Source == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				// The output folder is inside the root folder.
+				exclude = filepath.ToSlash(rel)
+			}
+		}
+	}
+	var config Config
+	var err error
+	if opts.Config == "" {
+		config, err = gen.LoadConfigFS(src, gen.CONFIGFILE)
+	} else {
+		config, err = gen.LoadConfig(opts.Config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.Drafts = opts.Drafts
+	logger := opts.Logger
+	if logger == nil {
+		logger = gen.DefaultLogger()
+	}
+	return &Builder{src, out, inPlace, exclude, config, logger, opts.Workers, opts.Manifest}, nil
+}
+
+// Build generates the whole site.
+// The error is for failures that prevent the build from starting;
+// errors while generating files are in the result.
+func (b *Builder) Build(ctx context.Context) (*BuildResult, error) {
+	return b.BuildFolder(ctx, ".")
+}
+
+// BuildFolder generates the part of the site under folder, relative to the root.
+func (b *Builder) BuildFolder(ctx context.Context, folder string) (*BuildResult, error) {
+	name, err := sourcePath(folder)
+	if err != nil {
+		return nil, err
+	}
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, err
+	}
+	return b.newBuild(site, b.out, b.inPlace, b.log).Run(ctx, name), nil
+}
+
+// Check generates the site into memory and returns the differences with the
+// files in the output, without writing anything. The output must be readable,
+// e.g., an output folder.
+func (b *Builder) Check(ctx context.Context) ([]Difference, *BuildResult, error) {
+	out, ok := b.out.(gen.ReadableOutput)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot read the output to check it")
+	}
+	mem, result, err := b.buildInMemory(ctx, ".")
+	if err != nil {
+		return nil, nil, err
+	}
+	prev, _, err := b.readManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	diffs, err := gen.Compare(out, mem, prev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return diffs, result, nil
+}
+
+// DryRun generates the part of the site under folder into memory, without
+// writing anything. The manifest of the result maps every generated file to
+// its source and templates.
+func (b *Builder) DryRun(ctx context.Context, folder string) (*BuildResult, error) {
+	name, err := sourcePath(folder)
+	if err != nil {
+		return nil, err
+	}
+	_, result, err := b.buildInMemory(ctx, name)
+	return result, err
+}
+
+func (b *Builder) buildInMemory(ctx context.Context, folder string) (*MemOutput, *BuildResult, error) {
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Start from the manifest of the output, so that the build leaves out
+	// the same stale files as a build into the output.
+	mem := gen.NewMemOutput()
+	prev, found, err := b.readManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	if found {
+		data, err := json.Marshal(prev)
+		if err != nil {
+			return nil, nil, err
+		}
+		mem.Files[gen.MANIFEST] = data
+	}
+	// Builds into memory only log errors, since they write nothing.
+	return mem, b.newBuild(site, mem, b.inPlace, gen.ErrorsOnly(b.log)).Run(ctx, folder), nil
+}
+
+// Clean removes the files generated by the previous build, as listed in its
+// manifest. Files modified since they were generated are kept.
+func (b *Builder) Clean() *BuildResult {
+	return b.newBuild(nil, b.out, b.inPlace, b.log).Clean()
+}
+
+// RenderFile generates a single .content or .md file, relative to the root.
+func (b *Builder) RenderFile(fname string) ([]byte, error) {
+	name, err := sourcePath(fname)
+	if err != nil {
+		return nil, err
+	}
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, err
+	}
+	build := b.newBuild(site, gen.NewMemOutput(), false, b.log)
+	var buf bytes.Buffer
+	if gen.IsContent(name) {
+		err = build.ProcessFileContent(&buf, name)
+	} else if gen.IsMarkdown(name) {
+		err = build.ProcessFileMarkdown(&buf, name)
+	} else {
+		err = fmt.Errorf("unknown file extension %s", fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Templates returns the template files that apply to a .content or .md file,
+// relative to the root, in the order in which they apply.
+func (b *Builder) Templates(fname string) ([]TemplateFile, error) {
+	name, err := sourcePath(fname)
+	if err != nil {
+		return nil, err
+	}
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, err
+	}
+	return b.newBuild(site, gen.NewMemOutput(), false, b.log).Templates(name)
+}
+
+// Explain tells how the templates of a .content or .md file, of a collection
+// item, or of a collection folder, relative to the root, are found.
+func (b *Builder) Explain(fname string) (*Explanation, error) {
+	name, err := sourcePath(fname)
+	if err != nil {
+		return nil, err
+	}
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, err
+	}
+	return b.newBuild(site, gen.NewMemOutput(), false, b.log).Explain(name)
+}
+
+// ListPages returns all pages and collection items of the site, ordered by URL.
+func (b *Builder) ListPages() ([]Page, error) {
+	site, err := b.LoadSite()
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]Page, 0, len(site.Pages))
+	pages = append(pages, site.Pages...)
+	for _, items := range site.Collections {
+		pages = append(pages, items...)
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+	return pages, nil
+}
+
+// LoadSite reads the pages and collection items of the site, without
+// generating anything.
+func (b *Builder) LoadSite() (*Site, error) {
+	return gen.LoadSite(b.src, b.config)
+}
+
+func (b *Builder) readManifest() (Manifest, bool, error) {
+	// The manifest of the last build into the output, if it can be read.
+	out, ok := b.out.(gen.ReadableOutput)
+	if b.manifest == "" {
+		if !ok {
+			return gen.Manifest{}, false, nil
+		}
+		return gen.ReadManifest(out, gen.MANIFEST)
+	}
+	manifest, found, err := gen.ReadManifest(gen.NewDirOutput(filepath.Dir(b.manifest)), filepath.Base(b.manifest))
+	if err == nil && !found && ok {
+		// Manifests used to be kept at the root of the output.
+		return gen.ReadManifest(out, gen.MANIFEST)
+	}
+	return manifest, found, err
+}
+
+func (b *Builder) newBuild(site *Site, out Output, inPlace bool, logger Logger) *gen.Build {
+	build := gen.NewBuild(b.src, out, site, inPlace, logger)
+	if b.manifest != "" && out == b.out {
+		build.SetManifest(gen.NewDirOutput(filepath.Dir(b.manifest)), filepath.Base(b.manifest))
+	}
+	if b.exclude != "" {
+		build.Exclude(b.exclude)
+	}
+	if b.workers > 0 {
+		build.SetWorkers(b.workers)
+	}
+	return build
+}
+
+func sourcePath(name string) (string, error) {
+	// Paths in the source are slash-separated and relative to the root.
+	p := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(p) {
+		return "", fmt.Errorf("%s is not inside the root folder", name)
+	}
+	return p, nil
+}